@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// fieldAliases maps a struct name to its JSON field names, and for each of those the
+// older names to try in order when the field itself is missing.  For example:
+//
+//	{"GuidebookSession": {"description_html": ["description"]}}
+var fieldAliases map[string]map[string][]string
+
+var loggedAliases sync.Map
+
+// loadFieldAliases reads the alias map from a JSON file
+func loadFieldAliases(path string) (map[string]map[string][]string, error) {
+	aliases := make(map[string]map[string][]string)
+	if path == "" {
+		return aliases, nil
+	}
+	aliasBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read field aliases: %w", err)
+	}
+	if err := json.Unmarshal(aliasBytes, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to decode field aliases from %q: %w", path, err)
+	}
+	return aliases, nil
+}
+
+// applyAliases fills in any fields of structName which are missing from data using
+// their configured aliases, returning data unchanged when there is nothing to do.
+func applyAliases(structName string, data []byte) ([]byte, error) {
+	aliases := fieldAliases[structName]
+	if len(aliases) == 0 {
+		return data, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	changed := false
+	for field, alternatives := range aliases {
+		if _, present := fields[field]; present {
+			continue
+		}
+		for _, alias := range alternatives {
+			if value, present := fields[alias]; present {
+				fields[field] = value
+				changed = true
+				if _, logged := loggedAliases.LoadOrStore(structName+"."+field, true); !logged {
+					log.Printf("Using %q from Guidebook as %s.%s", alias, structName, field)
+				}
+				break
+			}
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(fields)
+}
+
+func (gs *GuidebookSession) UnmarshalJSON(data []byte) error {
+	type plain GuidebookSession
+	data, err := applyAliases("GuidebookSession", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(gs))
+}
+
+func (gl *GuidebookLocation) UnmarshalJSON(data []byte) error {
+	type plain GuidebookLocation
+	data, err := applyAliases("GuidebookLocation", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(gl))
+}
+
+func (st *ScheduleTrack) UnmarshalJSON(data []byte) error {
+	type plain ScheduleTrack
+	data, err := applyAliases("ScheduleTrack", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(st))
+}
+
+func (cl *CustomList) UnmarshalJSON(data []byte) error {
+	type plain CustomList
+	data, err := applyAliases("CustomList", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(cl))
+}
+
+func (li *ListItem) UnmarshalJSON(data []byte) error {
+	type plain ListItem
+	data, err := applyAliases("ListItem", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(li))
+}
+
+func (cl *CatLink) UnmarshalJSON(data []byte) error {
+	type plain CatLink
+	data, err := applyAliases("CatLink", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(cl))
+}
+
+func (wv *WebView) UnmarshalJSON(data []byte) error {
+	type plain WebView
+	data, err := applyAliases("WebView", data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*plain)(wv))
+}
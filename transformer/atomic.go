@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// createSibling creates an empty temporary file beside path, readable by the webserver like
+// everything else we write, for building an output in before it replaces path.
+func createSibling(path string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeFileAtomic writes a file with write, into a temporary file beside path which is then
+// renamed over it.  The webserver serves these files while we write them, so a reader either
+// gets the old file or the new one, never half of one.  If anything fails the temporary file
+// is removed and whatever was at path is left as it was.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := createSibling(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// writeBytesAtomic is os.WriteFile, but atomic as for writeFileAtomic
+func writeBytesAtomic(path string, data []byte) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// BatchEntry is one guide to be processed in a batch run.  Anything left empty in
+// the manifest takes its value from the normal environment-based configuration, so
+// every guide must be given its own paths for any output that is turned on.
+type BatchEntry struct {
+	Name                string `json:"name"`
+	GuidebookID         string `json:"guide_id"`
+	GuidebookAPIKey     string `json:"api_key"`
+	OAuthClientID       string `json:"oauth_client_id"` // Only with GB_OAUTH_TOKEN_URL
+	OAuthClientSecret   string `json:"oauth_client_secret"`
+	GuidebookBaseURL    string `json:"base_url"`
+	SchedulePath        string `json:"schedule_path"`
+	StreamPath          string `json:"stream_path"`
+	StreamLinksPath     string `json:"stream_links_path"`
+	ChatLinksPath       string `json:"chat_links_path"`
+	ReplayLinksPath     string `json:"replay_links_path"`
+	StatePath           string `json:"state_path"`
+	SlotsPath           string `json:"slots_path"`
+	SQLitePath          string `json:"sqlite_path"`
+	TracksPath          string `json:"tracks_path"`
+	FacetsPath          string `json:"facets_path"`
+	NowNextPath         string `json:"now_next_path"`
+	ICSPath             string `json:"ics_path"`
+	SpeakersPath        string `json:"speakers_path"`
+	BundlePath          string `json:"bundle_path"`
+	UnmatchedReplayPath string `json:"unmatched_replay_path"`
+	ByLocationDir       string `json:"by_location_dir"`
+	ByDayDir            string `json:"by_day_dir"`
+	ByTrackDir          string `json:"by_track_dir"`
+	DumpRawDir          string `json:"dump_raw_dir"`
+	CSV                 bool   `json:"csv"`
+	ForceUTC            bool   `json:"force_utc"`
+	ReplayTag           bool   `json:"replay_tag"`
+}
+
+// BatchResult records how processing one guide went
+type BatchResult struct {
+	Name     string        `json:"name"`
+	GuideID  string        `json:"guide_id"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// BatchReport is the outcome of every guide in a batch run
+type BatchReport []BatchResult
+
+// Failed reports whether any guide in the batch failed
+func (br BatchReport) Failed() bool {
+	for _, result := range br {
+		if result.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func loadBatchManifest(path string) ([]BatchEntry, error) {
+	manifestBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch manifest: %w", err)
+	}
+	entries := make([]BatchEntry, 0)
+	if err := json.Unmarshal(manifestBytes, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode batch manifest %q: %w", path, err)
+	}
+	return entries, nil
+}
+
+// configFor overlays a manifest entry onto the base configuration
+func (be BatchEntry) configFor(base conf) conf {
+	c := base
+	override := func(target *string, value string) {
+		if value != "" {
+			*target = value
+		}
+	}
+	override(&c.GuidebookID, be.GuidebookID)
+	override(&c.GuidebookAPIKey, be.GuidebookAPIKey)
+	override(&c.GuidebookBaseURL, be.GuidebookBaseURL)
+	override(&c.SchedulePath, be.SchedulePath)
+	override(&c.StreamPath, be.StreamPath)
+	override(&c.StreamLinksPath, be.StreamLinksPath)
+	override(&c.ChatLinksPath, be.ChatLinksPath)
+	override(&c.ReplayLinksPath, be.ReplayLinksPath)
+	override(&c.StatePath, be.StatePath)
+	override(&c.SlotsPath, be.SlotsPath)
+	override(&c.SQLitePath, be.SQLitePath)
+	override(&c.TracksPath, be.TracksPath)
+	override(&c.FacetsPath, be.FacetsPath)
+	override(&c.NowNextPath, be.NowNextPath)
+	override(&c.ICSPath, be.ICSPath)
+	override(&c.SpeakersPath, be.SpeakersPath)
+	override(&c.BundlePath, be.BundlePath)
+	override(&c.UnmatchedReplayPath, be.UnmatchedReplayPath)
+	override(&c.ByLocationDir, be.ByLocationDir)
+	override(&c.ByDayDir, be.ByDayDir)
+	override(&c.ByTrackDir, be.ByTrackDir)
+	override(&c.DumpRawDir, be.DumpRawDir)
+	c.CSV = c.CSV || be.CSV
+	c.ForceUTC = c.ForceUTC || be.ForceUTC
+	c.ReplayTag = c.ReplayTag || be.ReplayTag
+	c.Budget = &RateBudget{} // Each guide is rate limited separately
+
+	// Each guide has its own token, even with the same client credentials, since a token
+	// for one guide's account may well not work for another's.  A guide with its own API key
+	// uses that rather than the OAuth client from the environment.
+	switch {
+	case be.OAuthClientID != "" && base.OAuth != nil:
+		c.OAuth = &OAuthTokenSource{TokenURL: base.OAuth.TokenURL, ClientID: be.OAuthClientID, ClientSecret: be.OAuthClientSecret}
+	case be.GuidebookAPIKey != "":
+		c.OAuth = nil
+	case base.OAuth != nil:
+		c.OAuth = &OAuthTokenSource{TokenURL: base.OAuth.TokenURL, ClientID: base.OAuth.ClientID, ClientSecret: base.OAuth.ClientSecret}
+	}
+	return c
+}
+
+// claimOutputPaths records which guide writes to each of c's outputs in claimed, and returns
+// an error instead if any of them is already written by another guide, or twice by this one.
+// Guides writing to the same place would overwrite each other's outputs, or worse, each
+// other's state.
+func claimOutputPaths(claimed map[string]string, name string, c conf) error {
+	paths := outputPaths(c)
+	for i, p := range paths {
+		path := filepath.Clean(p.path)
+		if other, exists := claimed[path]; exists {
+			return fmt.Errorf("%s is %q, which guide %s writes to as well", p.key, p.path, other)
+		}
+		for _, q := range paths[:i] {
+			if filepath.Clean(q.path) == path {
+				return fmt.Errorf("%s and %s must be set to different values, not both %q", q.key, p.key, p.path)
+			}
+		}
+	}
+	for _, p := range paths {
+		claimed[filepath.Clean(p.path)] = name
+	}
+	return nil
+}
+
+// runBatch processes the guides in the manifest, up to BATCH_CONCURRENCY of them at a time.
+// A failure in one guide is recorded and reported at the end, but doesn't stop the others
+// from being processed.  Only a manifest which can't be loaded is an error.
+func runBatch(ctx context.Context, base conf, manifestPath string) (BatchReport, error) {
+	entries, err := loadBatchManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := make(BatchReport, len(entries))
+	slots := make(chan struct{}, max(1, base.BatchConcurrency))
+	claimed := make(map[string]string)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		if entry.Name == "" {
+			entry.Name = fmt.Sprintf("#%d", i+1)
+		}
+		c := entry.configFor(base)
+		if err := claimOutputPaths(claimed, entry.Name, c); err != nil {
+			report[i] = BatchResult{Name: entry.Name, GuideID: c.GuidebookID, Error: err.Error()}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			log.Printf("Batch: processing guide %s (%s)", entry.Name, c.GuidebookID)
+			started := time.Now()
+			result := BatchResult{Name: entry.Name, GuideID: c.GuidebookID}
+			if err := run(ctx, c); err != nil {
+				result.Error = err.Error()
+			}
+			result.Duration = time.Since(started)
+			report[i] = result
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range report {
+		if result.Error == "" {
+			succeeded++
+			log.Printf("Batch: %s (%s) succeeded in %s", result.Name, result.GuideID, result.Duration.Round(time.Millisecond))
+		} else {
+			log.Printf("Batch: %s (%s) FAILED after %s: %s", result.Name, result.GuideID, result.Duration.Round(time.Millisecond), result.Error)
+		}
+	}
+	log.Printf("Batch: %d of %d guides processed successfully", succeeded, len(report))
+	return report, nil
+}
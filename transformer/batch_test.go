@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rateLimitedGuide is a Guidebook API which is empty, and rate limits the first request,
+// refusing any request without the authorization it expects
+func rateLimitedGuide(t *testing.T, authorization string, limited *atomic.Int32) *httptest.Server {
+	var requests atomic.Int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != authorization {
+			t.Errorf("%s was authorized with %q, want %q", r.URL.Path, got, authorization)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if requests.Add(1) == 1 {
+			limited.Add(1)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"count": 0, "next": null, "results": []}`)
+	}))
+}
+
+func TestRunBatch(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _, _ := r.BasicAuth()
+		fmt.Fprintf(w, `{"access_token": "token-%s", "expires_in": 3600}`, id)
+	}))
+	defer tokens.Close()
+	var limitedA, limitedB, limitedC atomic.Int32
+	guideA := rateLimitedGuide(t, "JWT key-a", &limitedA) // Its own API key, in spite of the OAuth client
+	defer guideA.Close()
+	guideB := rateLimitedGuide(t, "Bearer token-base", &limitedB)
+	defer guideB.Close()
+	guideC := rateLimitedGuide(t, "Bearer token-c", &limitedC)
+	defer guideC.Close()
+
+	dir := t.TempDir()
+	entries := make([]map[string]string, 0)
+	for _, guide := range []struct{ name, url, key, clientID, clientSecret string }{
+		{"a", guideA.URL, "key-a", "", ""},
+		{"b", guideB.URL, "", "", ""},
+		{"c", guideC.URL, "", "c", "c-secret"},
+	} {
+		entries = append(entries, map[string]string{
+			"name":                guide.name,
+			"guide_id":            guide.name,
+			"base_url":            guide.url,
+			"api_key":             guide.key,
+			"oauth_client_id":     guide.clientID,
+			"oauth_client_secret": guide.clientSecret,
+			"schedule_path":       filepath.Join(dir, guide.name+".json"),
+			"stream_path":         filepath.Join(dir, guide.name+".csv"),
+		})
+	}
+	manifest, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(dir, "manifest.json")
+	if err := os.WriteFile(manifestPath, manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	base := testConf(t, "")
+	base.LocalDataDir, base.DumpRawDir, base.StatePath = "", "", ""
+	base.CSV, base.Strict = false, false
+	base.OAuth = &OAuthTokenSource{TokenURL: tokens.URL, ClientID: "base", ClientSecret: "secret"}
+	base.MaxRetries, base.RetryDelay, base.InitialRetries = 2, time.Millisecond, 0
+	base.RateLimitRetries, base.RateLimitMaxDelay = 2, 2*time.Millisecond
+	report, err := runBatch(context.Background(), base, manifestPath)
+	if err != nil {
+		t.Fatalf("runBatch failed: %s", err)
+	}
+	for _, result := range report {
+		if result.Error != "" {
+			t.Errorf("guide %s failed: %s", result.Name, result.Error)
+		}
+		if _, err := os.Stat(filepath.Join(dir, result.Name+".json")); err != nil {
+			t.Errorf("guide %s has no schedule: %s", result.Name, err)
+		}
+	}
+	if limitedA.Load() != 1 || limitedB.Load() != 1 || limitedC.Load() != 1 {
+		t.Errorf("the guides were rate limited %d, %d and %d times, want once each", limitedA.Load(), limitedB.Load(), limitedC.Load())
+	}
+}
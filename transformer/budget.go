@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateBudget is the request count and rate-limit state for one guide.  Each guide has its own
+// API key and its own limits at Guidebook, so a 429 for one guide only holds back requests for
+// that guide, and guides in a batch can be fetched at the same time without slowing each other.
+type RateBudget struct {
+	mu          sync.Mutex
+	requests    int
+	pausedUntil time.Time
+}
+
+// Requests is how many successful requests have been made against this budget
+func (rb *RateBudget) Requests() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.requests
+}
+
+// Succeeded records a successful request and returns the new total
+func (rb *RateBudget) Succeeded() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.requests++
+	return rb.requests
+}
+
+// PauseFor holds back every request against this budget for at least d from now
+func (rb *RateBudget) PauseFor(d time.Duration) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if until := time.Now().Add(d); until.After(rb.pausedUntil) {
+		rb.pausedUntil = until
+	}
+}
+
+// Wait sleeps until any pause on this budget is over, or until ctx is done
+func (rb *RateBudget) Wait(ctx context.Context) error {
+	rb.mu.Lock()
+	wait := time.Until(rb.pausedUntil)
+	rb.mu.Unlock()
+	if wait > 0 {
+		return sleep(ctx, wait)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// PersonIndexEntry is one person, and the sessions they are in
+type PersonIndexEntry struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	SessionIDs []int  `json:"sessions"`
+}
+
+// Bundle is everything a single-page app needs, from a single generation
+type Bundle struct {
+	GuideID     string               `json:"guide_id"`
+	GeneratedAt string               `json:"generated_at"`
+	Sessions    []WatsonSession      `json:"sessions"`
+	People      []PersonIndexEntry   `json:"people"`
+	Tracks      []TrackSummary       `json:"tracks"`
+	Locations   []LocationIndexEntry `json:"locations"`
+}
+
+// PeopleIndex lists everyone in the sessions, sorted by name, with the sessions they are in
+func PeopleIndex(sessions []WatsonSession) []PersonIndexEntry {
+	byID := make(map[int]*PersonIndexEntry)
+	for _, ws := range sessions {
+		for _, p := range ws.People {
+			entry, exists := byID[p.ID]
+			if !exists {
+				entry = &PersonIndexEntry{ID: p.ID, Name: p.Name}
+				byID[p.ID] = entry
+			}
+			if len(entry.SessionIDs) == 0 || entry.SessionIDs[len(entry.SessionIDs)-1] != ws.ID {
+				entry.SessionIDs = append(entry.SessionIDs, ws.ID)
+			}
+		}
+	}
+
+	people := make([]PersonIndexEntry, 0, len(byID))
+	for _, entry := range byID {
+		people = append(people, *entry)
+	}
+	sort.Slice(people, func(i, j int) bool {
+		if people[i].Name != people[j].Name {
+			return people[i].Name < people[j].Name
+		}
+		return people[i].ID < people[j].ID
+	})
+	return people
+}
+
+// LocationIndex lists the locations the sessions are in, sorted by name
+func LocationIndex(sessions []WatsonSession) []LocationIndexEntry {
+	_, names := SessionsByLocation(sessions)
+	locations := make([]LocationIndexEntry, 0, len(names))
+	for id, name := range names {
+		locations = append(locations, LocationIndexEntry{ID: id, Name: name})
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Name != locations[j].Name {
+			return locations[i].Name < locations[j].Name
+		}
+		return locations[i].ID < locations[j].ID
+	})
+	return locations
+}
+
+// BuildBundle puts the sessions together with the people, track and location indexes
+func BuildBundle(c conf, gb GuideBook, sessions []WatsonSession) Bundle {
+	return Bundle{
+		GuideID:     c.GuidebookID,
+		GeneratedAt: c.Now().Format(time.RFC3339),
+		Sessions:    sessions,
+		People:      PeopleIndex(sessions),
+		Tracks:      TrackSummaries(gb, sessions, SORT_BY_NAME),
+		Locations:   LocationIndex(sessions),
+	}
+}
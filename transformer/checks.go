@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const CONFLICT_OVERLAP = "overlap"
+const CONFLICT_TIGHT_TURNAROUND = "tight turnaround"
+
+// PersonConflict is a pair of sessions for the same person which can't both be attended comfortably
+type PersonConflict struct {
+	Kind     string        `json:"kind"`
+	Person   Person        `json:"person"`
+	First    int           `json:"first_session"`
+	Second   int           `json:"second_session"`
+	Gap      time.Duration `json:"gap"`
+	Sessions [2]string     `json:"titles"`
+}
+
+// sharesLocation reports whether the two sessions have at least one location in common
+func sharesLocation(a, b WatsonSession) bool {
+	for _, id := range a.locationIDs {
+		if slices.Contains(b.locationIDs, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPersonConflicts finds every person who is in two sessions at once ("overlap"), or who
+// has to get between two different locations in less than minGap ("tight turnaround").
+func FindPersonConflicts(sessions []WatsonSession, minGap time.Duration) []PersonConflict {
+	byPerson := make(map[int][]WatsonSession)
+	people := make(map[int]Person)
+	for _, ws := range sessions {
+		for _, p := range ws.People {
+			byPerson[p.ID] = append(byPerson[p.ID], ws)
+			people[p.ID] = p
+		}
+	}
+
+	conflicts := make([]PersonConflict, 0)
+	for id, personSessions := range byPerson {
+		sort.Slice(personSessions, func(i, j int) bool {
+			return personSessions[i].start.Before(personSessions[j].start)
+		})
+		for i, first := range personSessions {
+			for _, second := range personSessions[i+1:] {
+				if second.ID == first.ID {
+					continue // The same session exploded into several rooms
+				}
+				gap := second.start.Sub(first.finish)
+				if gap >= minGap {
+					break
+				}
+				conflict := PersonConflict{
+					Person:   people[id],
+					First:    first.ID,
+					Second:   second.ID,
+					Gap:      gap,
+					Sessions: [2]string{first.Name, second.Name},
+				}
+				if gap < 0 {
+					conflict.Kind = CONFLICT_OVERLAP
+				} else if !sharesLocation(first, second) {
+					conflict.Kind = CONFLICT_TIGHT_TURNAROUND
+				} else {
+					continue
+				}
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Person.Name != conflicts[j].Person.Name {
+			return conflicts[i].Person.Name < conflicts[j].Person.Name
+		}
+		return conflicts[i].First < conflicts[j].First
+	})
+	return conflicts
+}
+
+// ReportPersonConflicts logs the conflicts, grouped by their kind
+func ReportPersonConflicts(conflicts []PersonConflict) {
+	for _, kind := range []string{CONFLICT_OVERLAP, CONFLICT_TIGHT_TURNAROUND} {
+		count := 0
+		for _, c := range conflicts {
+			if c.Kind != kind {
+				continue
+			}
+			count++
+			log.Printf("%s: %s is in %d (%s) and %d (%s) with a gap of %s", kind, c.Person.Name, c.First, c.Sessions[0], c.Second, c.Sessions[1], c.Gap)
+		}
+		log.Printf("There were %d people conflicts of kind %q", count, kind)
+	}
+}
+
+// FindDuplicateTitles finds the titles shared by more than one session, with the IDs of those sessions
+func FindDuplicateTitles(sessions []WatsonSession) map[string][]int {
+	byTitle := make(map[string][]int)
+	for _, ws := range sessions {
+		title := strings.TrimSpace(ws.Name)
+		if !slices.Contains(byTitle[title], ws.ID) { // The same session in several rooms isn't a duplicate
+			byTitle[title] = append(byTitle[title], ws.ID)
+		}
+	}
+	for title, ids := range byTitle {
+		if len(ids) < 2 {
+			delete(byTitle, title)
+			continue
+		}
+		sort.Ints(ids)
+	}
+	return byTitle
+}
+
+// ReportDuplicateTitles logs each repeated title, and the sessions which have it
+func ReportDuplicateTitles(duplicates map[string][]int) {
+	titles := make([]string, 0, len(duplicates))
+	for title := range duplicates {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	log.Printf("There were %d titles used by more than one session", len(titles))
+	for _, title := range titles {
+		log.Printf("\t%q is used by sessions %v", title, duplicates[title])
+	}
+}
+
+// FindVirtualWithoutStream finds the virtual sessions which have no valid stream link for attendees to follow
+func FindVirtualWithoutStream(sessions []WatsonSession) []WatsonSession {
+	missing := make([]WatsonSession, 0)
+	for _, ws := range sessions {
+		if ws.virtual && !validLink(ws.Links.Session) {
+			missing = append(missing, ws)
+		}
+	}
+	return missing
+}
+
+// checkVirtualStreams logs the virtual sessions without a stream link, failing in strict mode
+func checkVirtualStreams(c conf, sessions []WatsonSession) error {
+	if !slices.Contains(c.EmitLinks, LINK_SESSION) {
+		return nil
+	}
+	missing := FindVirtualWithoutStream(sessions)
+	if len(missing) == 0 {
+		return nil
+	}
+	log.Printf("There were %d virtual sessions without a stream link:", len(missing))
+	for _, ws := range missing {
+		log.Printf("\t%d: %s", ws.ID, ws.Name)
+	}
+	if c.Strict {
+		return fmt.Errorf("%d virtual sessions have no stream link", len(missing))
+	}
+	return nil
+}
+
+// FindUnlocatedInPerson finds the in-person sessions which have no location that we know of,
+// so would be printed in the program without a room.
+func FindUnlocatedInPerson(gb GuideBook, sessions []WatsonSession) []WatsonSession {
+	missing := make([]WatsonSession, 0)
+	for _, ws := range sessions {
+		if !ws.in_person {
+			continue
+		}
+		located := false
+		for _, id := range ws.locationIDs {
+			if _, known := gb.Locations[id]; known {
+				located = true
+				break
+			}
+		}
+		if !located {
+			missing = append(missing, ws)
+		}
+	}
+	return missing
+}
+
+// checkInPersonLocations logs the in-person sessions without a location, failing in strict mode
+func checkInPersonLocations(c conf, gb GuideBook, sessions []WatsonSession) error {
+	missing := FindUnlocatedInPerson(gb, sessions)
+	if len(missing) == 0 {
+		return nil
+	}
+	log.Printf("There were %d in-person sessions without a location:", len(missing))
+	for _, ws := range missing {
+		log.Printf("\t%d: %s", ws.ID, ws.Name)
+	}
+	if c.Strict {
+		return fmt.Errorf("%d in-person sessions have no location", len(missing))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFindPersonConflicts(t *testing.T) {
+	minGap := 15 * time.Minute
+	start := time.Date(2025, 8, 14, 10, 0, 0, 0, time.UTC)
+	alice := Person{ID: 1, Name: "Alice"}
+	first := WatsonSession{ID: 1, Name: "First", People: []Person{alice}, locationIDs: []int{10}, start: start, finish: start.Add(time.Hour)}
+	tests := []struct {
+		name     string
+		gap      time.Duration
+		location int
+		want     string // The kind of conflict, if there is one
+	}{
+		{"overlapping", -30 * time.Minute, 11, CONFLICT_OVERLAP},
+		{"overlapping in the same room", -30 * time.Minute, 10, CONFLICT_OVERLAP},
+		{"a minute under the gap", minGap - time.Minute, 11, CONFLICT_TIGHT_TURNAROUND},
+		{"exactly the gap", minGap, 11, ""},
+		{"a minute over the gap", minGap + time.Minute, 11, ""},
+		{"back to back", 0, 11, CONFLICT_TIGHT_TURNAROUND},
+		{"back to back in the same room", 0, 10, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := WatsonSession{
+				ID:          2,
+				Name:        "Second",
+				People:      []Person{alice},
+				locationIDs: []int{tt.location},
+				start:       first.finish.Add(tt.gap),
+			}
+			second.finish = second.start.Add(time.Hour)
+			conflicts := FindPersonConflicts([]WatsonSession{second, first}, minGap)
+			if tt.want == "" {
+				if len(conflicts) != 0 {
+					t.Errorf("found %+v, want no conflicts", conflicts)
+				}
+				return
+			}
+			if len(conflicts) != 1 {
+				t.Fatalf("found %+v, want one %s", conflicts, tt.want)
+			}
+			if got := conflicts[0]; got.Kind != tt.want || got.First != 1 || got.Second != 2 || got.Gap != tt.gap {
+				t.Errorf("found %+v, want a %s from 1 to 2 with a gap of %s", got, tt.want, tt.gap)
+			}
+		})
+	}
+}
+
+func TestUnlocatedInPerson(t *testing.T) {
+	c := testConf(t, "")
+	gb, sessions := testSessions(t, c)
+	// Lost Room is neither in person nor virtual, so is taken to be in person, but has no room.
+	// The virtual panel has no room either, and doesn't need one.
+	missing := FindUnlocatedInPerson(gb, sessions)
+	if got := sessionIDs(missing); !slices.Equal(got, []int{5}) {
+		t.Errorf("found %v without a location, want [5]", got)
+	}
+
+	c.Strict = false
+	if err := checkInPersonLocations(c, gb, sessions); err != nil {
+		t.Errorf("checkInPersonLocations failed when not strict: %s", err)
+	}
+	c.Strict = true
+	if err := checkInPersonLocations(c, gb, sessions); err == nil {
+		t.Errorf("checkInPersonLocations didn't fail when strict")
+	}
+}
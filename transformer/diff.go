@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diffSession is as much of a session from a previously written schedule as we compare
+type diffSession struct {
+	ID              int      `json:"id"`
+	EntryID         string   `json:"entry_id,omitempty"`
+	Name            string   `json:"title"`
+	StartTime       string   `json:"dateTime"`
+	DurationMinutes int      `json:"mins"`
+	Locations       []string `json:"loc"`
+	People          []Person `json:"people"`
+}
+
+func (ds diffSession) key() string {
+	if ds.EntryID != "" {
+		return ds.EntryID
+	}
+	return strconv.Itoa(ds.ID)
+}
+
+func (ds diffSession) peopleNames() []string {
+	names := make([]string, 0, len(ds.People))
+	for _, p := range ds.People {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SessionChange is a session which is in both schedules, with the fields which differ
+type SessionChange struct {
+	Old    diffSession
+	New    diffSession
+	Fields []string
+}
+
+// ScheduleDiff is what changed from one schedule to the next
+type ScheduleDiff struct {
+	Added   []diffSession
+	Removed []diffSession
+	Changed []SessionChange
+}
+
+func (sd ScheduleDiff) Empty() bool {
+	return len(sd.Added) == 0 && len(sd.Removed) == 0 && len(sd.Changed) == 0
+}
+
+// LoadScheduleForDiff reads a schedule.json we wrote before, in either OUTPUT_SHAPE
+func LoadScheduleForDiff(path string) ([]diffSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var sessions []diffSession
+	if bytes.HasPrefix(data, []byte("{")) {
+		var byID struct {
+			Sessions map[string]diffSession `json:"sessions"`
+			Order    []string               `json:"order"`
+		}
+		if err := json.Unmarshal(data, &byID); err != nil {
+			return nil, fmt.Errorf("unable to parse %q as a schedule: %w", path, err)
+		}
+		for _, id := range byID.Order {
+			sessions = append(sessions, byID.Sessions[id])
+		}
+		return sessions, nil
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("unable to parse %q as a schedule: %w", path, err)
+	}
+	return sessions, nil
+}
+
+func asDiffSession(ws WatsonSession) diffSession {
+	return diffSession{
+		ID:              ws.ID,
+		EntryID:         ws.EntryID,
+		Name:            ws.Name,
+		StartTime:       ws.StartTime,
+		DurationMinutes: ws.DurationMinutes,
+		Locations:       ws.Locations,
+		People:          ws.People,
+	}
+}
+
+// DiffSchedules compares the sessions by ID, in the order of the new schedule
+func DiffSchedules(old []diffSession, sessions []WatsonSession) ScheduleDiff {
+	var diff ScheduleDiff
+	oldByKey := make(map[string]diffSession, len(old))
+	for _, ds := range old {
+		oldByKey[ds.key()] = ds
+	}
+	seen := make(map[string]bool, len(sessions))
+	for _, ws := range sessions {
+		ds := asDiffSession(ws)
+		seen[ds.key()] = true
+		before, ok := oldByKey[ds.key()]
+		if !ok {
+			diff.Added = append(diff.Added, ds)
+			continue
+		}
+		var fields []string
+		if before.StartTime != ds.StartTime || before.DurationMinutes != ds.DurationMinutes {
+			fields = append(fields, "time")
+		}
+		if !slices.Equal(before.Locations, ds.Locations) {
+			fields = append(fields, "location")
+		}
+		if !slices.Equal(before.peopleNames(), ds.peopleNames()) {
+			fields = append(fields, "people")
+		}
+		if before.Name != ds.Name {
+			fields = append(fields, "name")
+		}
+		if len(fields) > 0 {
+			diff.Changed = append(diff.Changed, SessionChange{Old: before, New: ds, Fields: fields})
+		}
+	}
+	for _, ds := range old {
+		if !seen[ds.key()] {
+			diff.Removed = append(diff.Removed, ds)
+		}
+	}
+	return diff
+}
+
+// WriteScheduleDiff writes the changes as a plain text report, suitable for announcing
+func WriteScheduleDiff(w io.Writer, diff ScheduleDiff) {
+	if diff.Empty() {
+		fmt.Fprintln(w, "No changes to the schedule.")
+		return
+	}
+	if len(diff.Added) > 0 {
+		fmt.Fprintf(w, "Added (%d):\n", len(diff.Added))
+		for _, ds := range diff.Added {
+			fmt.Fprintf(w, "  %s at %s in %s\n", ds.Name, ds.StartTime, strings.Join(ds.Locations, ", "))
+		}
+	}
+	if len(diff.Removed) > 0 {
+		fmt.Fprintf(w, "Removed (%d):\n", len(diff.Removed))
+		for _, ds := range diff.Removed {
+			fmt.Fprintf(w, "  %s at %s in %s\n", ds.Name, ds.StartTime, strings.Join(ds.Locations, ", "))
+		}
+	}
+	if len(diff.Changed) > 0 {
+		fmt.Fprintf(w, "Changed (%d):\n", len(diff.Changed))
+		for _, change := range diff.Changed {
+			fmt.Fprintf(w, "  %s:\n", change.New.Name)
+			for _, field := range change.Fields {
+				var before, after string
+				switch field {
+				case "time":
+					before = fmt.Sprintf("%s for %d mins", change.Old.StartTime, change.Old.DurationMinutes)
+					after = fmt.Sprintf("%s for %d mins", change.New.StartTime, change.New.DurationMinutes)
+				case "location":
+					before, after = strings.Join(change.Old.Locations, ", "), strings.Join(change.New.Locations, ", ")
+				case "people":
+					before, after = strings.Join(change.Old.peopleNames(), ", "), strings.Join(change.New.peopleNames(), ", ")
+				case "name":
+					before, after = change.Old.Name, change.New.Name
+				}
+				fmt.Fprintf(w, "    %s: %s -> %s\n", field, before, after)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffSchedules(t *testing.T) {
+	old := []diffSession{
+		{ID: 1, Name: "Unchanged", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, Locations: []string{"Room A"}},
+		{ID: 2, Name: "Moved", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, Locations: []string{"Room A"}},
+		{ID: 3, Name: "Old Title", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, People: []Person{{Name: "Bob"}, {Name: "Alice"}}},
+		{ID: 4, Name: "Cancelled", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60},
+		{ID: 6, EntryID: "6-1", Name: "Exploded", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, Locations: []string{"Room A"}},
+	}
+	sessions := []WatsonSession{
+		{ID: 1, Name: "Unchanged", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, Locations: []string{"Room A"}},
+		{ID: 2, Name: "Moved", StartTime: "2025-08-14T11:00:00Z", DurationMinutes: 60, Locations: []string{"Room B"}},
+		{ID: 3, Name: "New Title", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, People: []Person{{Name: "Alice"}, {Name: "Bob"}}},
+		{ID: 5, Name: "Added", StartTime: "2025-08-14T12:00:00Z", DurationMinutes: 30},
+		{ID: 6, EntryID: "6-1", Name: "Exploded", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, Locations: []string{"Room A"}},
+		{ID: 6, EntryID: "6-2", Name: "Exploded", StartTime: "2025-08-14T10:00:00Z", DurationMinutes: 60, Locations: []string{"Room B"}},
+	}
+	diff := DiffSchedules(old, sessions)
+
+	keys := func(sessions []diffSession) []string {
+		result := make([]string, 0, len(sessions))
+		for _, ds := range sessions {
+			result = append(result, ds.key())
+		}
+		return result
+	}
+	if got, want := keys(diff.Added), []string{"5", "6-2"}; !slices.Equal(got, want) {
+		t.Errorf("added %v, want %v", got, want)
+	}
+	if got, want := keys(diff.Removed), []string{"4"}; !slices.Equal(got, want) {
+		t.Errorf("removed %v, want %v", got, want)
+	}
+	if len(diff.Changed) != 2 {
+		t.Fatalf("changed %v, want sessions 2 and 3", diff.Changed)
+	}
+	// The same people in a different order isn't a change
+	changes := map[int][]string{2: {"time", "location"}, 3: {"name"}}
+	for _, change := range diff.Changed {
+		if want := changes[change.New.ID]; !slices.Equal(change.Fields, want) {
+			t.Errorf("session %d changed %v, want %v", change.New.ID, change.Fields, want)
+		}
+	}
+	if DiffSchedules(old, nil).Empty() || !DiffSchedules(nil, nil).Empty() {
+		t.Error("Empty is wrong")
+	}
+}
@@ -1,59 +1,289 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
+	"encoding/csv"
 	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 )
 
-func StreamingCSV(w io.Writer, sessions []WatsonSession) {
-	fmt.Fprintf(w, "%q,%q,%q\n", "Title", "StartTime", "StreamingURL")
-	for _, ws := range sessions {
-		if ws.virtual {
-			fmt.Fprintf(w, "%q,%q,%q\n", ws.Name, ws.StartTime, ws.Links.Session)
+// csvWriter is a csv.Writer which can also quote every field, as some importers insist on
+type csvWriter struct {
+	*csv.Writer
+	out      *bufio.Writer
+	comma    rune
+	quoteAll bool
+	err      error
+}
+
+// newCSVWriter starts a CSV file with the configured delimiter, writing the UTF-8 byte order
+// mark first if CSV_BOM is set so that Excel recognises the encoding.  Fields are only quoted
+// where they need to be, unless CSV_QUOTE_ALL is set.
+func newCSVWriter(w io.Writer, c conf) *csvWriter {
+	if c.CSVBOM {
+		io.WriteString(w, "\uFEFF")
+	}
+	cw := &csvWriter{Writer: csv.NewWriter(w), out: bufio.NewWriter(w), comma: c.CSVDelimiter, quoteAll: c.CSVQuoteAll}
+	cw.Writer.Comma = c.CSVDelimiter
+	return cw
+}
+
+func (cw *csvWriter) Write(record []string) error {
+	if !cw.quoteAll {
+		return cw.Writer.Write(record)
+	}
+	if cw.err != nil {
+		return cw.err
+	}
+	for i, field := range record {
+		if i > 0 {
+			cw.out.WriteRune(cw.comma)
 		}
+		cw.out.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
 	}
+	_, cw.err = cw.out.WriteString("\n")
+	return cw.err
+}
+
+func (cw *csvWriter) Flush() {
+	if !cw.quoteAll {
+		cw.Writer.Flush()
+		return
+	}
+	if err := cw.out.Flush(); cw.err == nil {
+		cw.err = err
+	}
+}
+
+func (cw *csvWriter) Error() error {
+	if !cw.quoteAll {
+		return cw.Writer.Error()
+	}
+	return cw.err
+}
+
+// linkCSVHeader is the header Guidebook expects when importing links
+var linkCSVHeader = []string{"Session ID (Optional)", "Session Name (Optional)", "Link To Session ID (Optional)",
+	"Link To Session Name (Optional)", "Link To Custom List Item ID (Optional)", "Link To Custom List Item Name (Optional)",
+	"Link To URLs (Optional)", "URL Names (Optional)"}
+
+// LinkRow is one session's link, as written to one of the link CSVs
+type LinkRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
 }
 
-func StreamLinksCSV(w io.Writer, sessions []WatsonSession) {
+const LINK_ADDED = "added"
+const LINK_CHANGED = "changed"
+const LINK_REMOVED = "removed"
+
+// LinkChange is a link which is different from the previous run
+type LinkChange struct {
+	LinkRow
+	Change string
+}
+
+func linkCSVRow(row LinkRow, urlName string) []string {
+	return []string{strconv.Itoa(row.ID), row.Name, "", "", "", "", row.URL, urlName}
+}
 
-	fmt.Fprintf(w, "%q,%q,%q,%q,%q,%q,%q,%q\n", "Session ID (Optional)", "Session Name (Optional)", "Link To Session ID (Optional)",
-		"Link To Session Name (Optional)", "Link To Custom List Item ID (Optional)", "Link To Custom List Item Name (Optional)",
-		"Link To URLs (Optional)", "URL Names (Optional)")
+// linkRows makes a row for each session which linkFor gives a link.  With MULTI_ROOM=explode a
+// session comes once per location, but Guidebook only wants one row for it.
+func linkRows(sessions []WatsonSession, linkFor func(ws WatsonSession) string) []LinkRow {
+	rows := make([]LinkRow, 0)
+	seen := make(map[int]bool)
 	for _, ws := range sessions {
-		if stream_session_ids[ws.ID] && ws.Links.Session != "" {
-			fmt.Fprintf(w, "%d,%q,%q,%q,%q,%q,%q,%q\n", ws.ID, ws.Name, "", "", "", "", ws.Links.Session, "Join the Stream")
+		if url := linkFor(ws); url != "" && !seen[ws.ID] {
+			seen[ws.ID] = true
+			rows = append(rows, LinkRow{ID: ws.ID, Name: ws.Name, URL: url})
 		}
 	}
+	return rows
 }
 
-func ReplayLinksCSV(w io.Writer, sessions []WatsonSession) {
-
-	fmt.Fprintf(w, "%q,%q,%q,%q,%q,%q,%q,%q\n", "Session ID (Optional)", "Session Name (Optional)", "Link To Session ID (Optional)",
-		"Link To Session Name (Optional)", "Link To Custom List Item ID (Optional)", "Link To Custom List Item Name (Optional)",
-		"Link To URLs (Optional)", "URL Names (Optional)")
+func StreamingCSV(w io.Writer, c conf, sessions []WatsonSession) error {
+	cw := newCSVWriter(w, c)
+	cw.Write([]string{"Title", "StartTime", "StreamingURL"})
+	seen := make(map[int]bool)
 	for _, ws := range sessions {
-		if stream_session_ids[ws.ID] && ws.Links.Replay != "" {
-			fmt.Fprintf(w, "%d,%q,%q,%q,%q,%q,%q,%q\n", ws.ID, ws.Name, "", "", "", "", ws.Links.Replay, "Watch the Replay")
+		if ws.virtual && !seen[ws.ID] {
+			seen[ws.ID] = true
+			cw.Write([]string{ws.Name, ws.StartTime, ws.Links.Session})
 		}
 	}
+	cw.Flush()
+	return cw.Error()
 }
 
-func ChatLinksCSV(w io.Writer, sessions []WatsonSession) {
+func StreamLinkRows(sessions []WatsonSession) []LinkRow {
+	return linkRows(sessions, func(ws WatsonSession) string {
+		if stream_session_ids[ws.ID] && ws.Links.Session != "" {
+			return ws.Links.Session
+		}
+		return ""
+	})
+}
 
-	fmt.Fprintf(w, "%q,%q,%q,%q,%q,%q,%q,%q\n", "Session ID (Optional)", "Session Name (Optional)", "Link To Session ID (Optional)",
-		"Link To Session Name (Optional)", "Link To Custom List Item ID (Optional)", "Link To Custom List Item Name (Optional)",
-		"Link To URLs (Optional)", "URL Names (Optional)")
-	for _, ws := range sessions {
+func ReplayLinkRows(sessions []WatsonSession) []LinkRow {
+	return linkRows(sessions, func(ws WatsonSession) string {
+		if stream_session_ids[ws.ID] && ws.Links.Replay != "" {
+			return ws.Links.Replay
+		}
+		return ""
+	})
+}
+
+func ChatLinkRows(sessions []WatsonSession) []LinkRow {
+	return linkRows(sessions, func(ws WatsonSession) string {
 		if chat_session_ids[ws.ID] && ws.Links.Chat != "" {
-			fmt.Fprintf(w, "%d,%q,%q,%q,%q,%q,%q,%q\n", ws.ID, ws.Name, "", "", "", "", ws.Links.Chat, "Join the Discussion")
+			return ws.Links.Chat
 		}
+		return ""
+	})
+}
+
+// LinksCSV writes the link rows in the form Guidebook imports
+func LinksCSV(w io.Writer, c conf, rows []LinkRow, urlName string) error {
+	cw := newCSVWriter(w, c)
+	cw.Write(linkCSVHeader)
+	for _, row := range rows {
+		cw.Write(linkCSVRow(row, urlName))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// LinkDelta compares the link rows with those from the previous run, finding the ones which
+// were added, changed or removed.  A row has changed when its session's name or its URL has,
+// as either is a column in the CSV.
+func LinkDelta(previous, current []LinkRow) []LinkChange {
+	before := make(map[int]LinkRow, len(previous))
+	for _, row := range previous {
+		before[row.ID] = row
+	}
+
+	changes := make([]LinkChange, 0)
+	seen := make(map[int]bool, len(current))
+	for _, row := range current {
+		if seen[row.ID] {
+			continue
+		}
+		seen[row.ID] = true
+		old, existed := before[row.ID]
+		delete(before, row.ID)
+		if !existed {
+			changes = append(changes, LinkChange{LinkRow: row, Change: LINK_ADDED})
+		} else if old.Name != row.Name || old.URL != row.URL {
+			changes = append(changes, LinkChange{LinkRow: row, Change: LINK_CHANGED})
+		}
+	}
+	for _, row := range previous {
+		if _, removed := before[row.ID]; removed {
+			changes = append(changes, LinkChange{LinkRow: row, Change: LINK_REMOVED})
+			delete(before, row.ID) // Only once, even if an older state had it twice
+		}
+	}
+	return changes
+}
+
+// LinkDeltaCSV writes just the changed link rows for a quicker import, with an extra column
+// saying whether each was added, changed or removed.  Removed rows have no URL.
+func LinkDeltaCSV(w io.Writer, c conf, changes []LinkChange, urlName string) error {
+	cw := newCSVWriter(w, c)
+	cw.Write(append(slices.Clone(linkCSVHeader), "Change"))
+	for _, change := range changes {
+		row := change.LinkRow
+		if change.Change == LINK_REMOVED {
+			row.URL = ""
+		}
+		cw.Write(append(linkCSVRow(row, urlName), change.Change))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLinkCSVs writes the stream, chat and replay link CSVs and, when CSV_DELTA is set and we
+// know what we wrote last time, a delta CSV of what changed alongside each of them.  It returns
+// the rows written, to be remembered for next time.
+func WriteLinkCSVs(c conf, sessions []WatsonSession, previous State, hadPrevious bool) map[string][]LinkRow {
+	linkCSVs := []struct {
+		kind    string
+		path    string
+		urlName string
+		rows    []LinkRow
+	}{
+		{"chat", c.ChatLinksPath, "Join the Discussion", ChatLinkRows(sessions)},
+		{"stream", c.StreamLinksPath, "Join the Stream", StreamLinkRows(sessions)},
+		{"replay", c.ReplayLinksPath, "Watch the Replay", ReplayLinkRows(sessions)},
 	}
+
+	written := make(map[string][]LinkRow)
+	for _, lc := range linkCSVs {
+		err := writeFileAtomic(lc.path, func(w io.Writer) error { return LinksCSV(w, c, lc.rows, lc.urlName) })
+		if err != nil {
+			log.Printf("Error writing CSV to %q: %s", lc.path, err.Error())
+			continue
+		}
+		written[lc.kind] = lc.rows
+
+		if !c.CSVDelta || !hadPrevious {
+			continue
+		}
+		changes := LinkDelta(previous.Links[lc.kind], lc.rows)
+		err = writeFileAtomic(deltaPath(lc.path), func(w io.Writer) error { return LinkDeltaCSV(w, c, changes, lc.urlName) })
+		if err != nil {
+			log.Printf("Error writing CSV to %q: %s", deltaPath(lc.path), err.Error())
+			continue
+		}
+		log.Printf("Wrote %d changed %s links to %q", len(changes), lc.kind, deltaPath(lc.path))
+	}
+	return written
+}
+
+// deltaPath is where the delta for a link CSV is written, e.g. chat_links-delta.csv
+func deltaPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-delta" + ext
+}
+
+// UnmatchedReplay is a title we were told has no replay which didn't match any streamed session
+type UnmatchedReplay struct {
+	Title          string `json:"title"`
+	ClosestSession string `json:"closest_session,omitempty"`
+}
+
+// WriteUnmatchedReplays writes the unmatched titles to path, as CSV if it ends in ".csv" and
+// otherwise as JSON, with the closest session name to each for the video team to reconcile.
+func WriteUnmatchedReplays(path string, c conf, titles []string, sessions []WatsonSession) error {
+	unmatched := make([]UnmatchedReplay, 0, len(titles))
+	for _, title := range titles {
+		unmatched = append(unmatched, UnmatchedReplay{Title: title, ClosestSession: ClosestSessionName(title, sessions)})
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		return WriteJSONFile(path, unmatched)
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		cw := newCSVWriter(w, c)
+		cw.Write([]string{"Title", "Closest Session"})
+		for _, u := range unmatched {
+			cw.Write([]string{u.Title, u.ClosestSession})
+		}
+		cw.Flush()
+		return cw.Error()
+	})
 }
 
 // Ugly, but hey...
 var stream_session_ids map[int]bool
 var chat_session_ids map[int]bool
 var no_replay_titles map[string]bool
+var no_replay_normalized map[string]string // The no replay titles by their normalizeTitle
 
 func init() {
 	stream_sessions := []int{
@@ -1356,7 +1586,9 @@ func init() {
 		"Indie? Trad? Why Not Both?",
 	}
 	no_replay_titles = make(map[string]bool, len(no_replay))
+	no_replay_normalized = make(map[string]string, len(no_replay))
 	for _, stream := range no_replay {
 		no_replay_titles[stream] = true
+		no_replay_normalized[normalizeTitle(stream)] = stream
 	}
 }
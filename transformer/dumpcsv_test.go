@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestCSVWriter(t *testing.T) {
+	record := []string{"31607049", `Say "hello"`, "", "a;b"}
+	tests := []struct {
+		name string
+		c    conf
+		want string
+	}{
+		{"plain", conf{CSVDelimiter: ','}, "31607049,\"Say \"\"hello\"\"\",,a;b\n"},
+		{"quote all", conf{CSVDelimiter: ',', CSVQuoteAll: true}, "\"31607049\",\"Say \"\"hello\"\"\",\"\",\"a;b\"\n"},
+		{"semicolons", conf{CSVDelimiter: ';'}, "31607049;\"Say \"\"hello\"\"\";;\"a;b\"\n"},
+		{"BOM", conf{CSVDelimiter: ',', CSVBOM: true}, "\uFEFF31607049,\"Say \"\"hello\"\"\",,a;b\n"},
+		{"BOM and quote all", conf{CSVDelimiter: ',', CSVBOM: true, CSVQuoteAll: true}, "\uFEFF\"31607049\",\"Say \"\"hello\"\"\",\"\",\"a;b\"\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		cw := newCSVWriter(&out, tt.c)
+		cw.Write(record)
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			t.Errorf("%s: writing failed: %s", tt.name, err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLinkRows(t *testing.T) {
+	// As MULTI_ROOM=explode gives them, once per location
+	sessions := []WatsonSession{
+		{ID: 1, Name: "One", Locations: []string{"Room A"}, Links: Links{Chat: "https://chat/1"}},
+		{ID: 1, Name: "One", Locations: []string{"Room B"}, Links: Links{Chat: "https://chat/1"}},
+		{ID: 2, Name: "Two", Links: Links{Chat: ""}},
+		{ID: 3, Name: "Three", Links: Links{Chat: "https://chat/3"}},
+	}
+	got := linkRows(sessions, func(ws WatsonSession) string { return ws.Links.Chat })
+	want := []LinkRow{
+		{ID: 1, Name: "One", URL: "https://chat/1"},
+		{ID: 3, Name: "Three", URL: "https://chat/3"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("linkRows = %v, want %v", got, want)
+	}
+}
+
+func TestLinkDelta(t *testing.T) {
+	previous := []LinkRow{
+		{ID: 1, Name: "Same", URL: "https://a/1"},
+		{ID: 2, Name: "Moved", URL: "https://a/2"},
+		{ID: 3, Name: "Old Name", URL: "https://a/3"},
+		{ID: 4, Name: "Gone", URL: "https://a/4"},
+		{ID: 4, Name: "Gone", URL: "https://a/4"},
+	}
+	current := []LinkRow{
+		{ID: 1, Name: "Same", URL: "https://a/1"},
+		{ID: 2, Name: "Moved", URL: "https://b/2"},
+		{ID: 3, Name: "New Name", URL: "https://a/3"},
+		{ID: 5, Name: "New", URL: "https://a/5"},
+		{ID: 5, Name: "New", URL: "https://a/5"},
+	}
+	want := []LinkChange{
+		{LinkRow: LinkRow{ID: 2, Name: "Moved", URL: "https://b/2"}, Change: LINK_CHANGED},
+		{LinkRow: LinkRow{ID: 3, Name: "New Name", URL: "https://a/3"}, Change: LINK_CHANGED},
+		{LinkRow: LinkRow{ID: 5, Name: "New", URL: "https://a/5"}, Change: LINK_ADDED},
+		{LinkRow: LinkRow{ID: 4, Name: "Gone", URL: "https://a/4"}, Change: LINK_REMOVED},
+	}
+	if got := LinkDelta(previous, current); !slices.Equal(got, want) {
+		t.Errorf("LinkDelta = %v, want %v", got, want)
+	}
+	if got := LinkDelta(current, current); len(got) != 0 {
+		t.Errorf("LinkDelta with nothing changed = %v, want nothing", got)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"net/url"
+)
+
+// validLink reports whether link is an absolute http(s) URL
+func validLink(link string) bool {
+	if link == "" {
+		return false
+	}
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// FilterStreamable keeps only the sessions which have a valid stream link, logging how many were dropped
+func FilterStreamable(sessions []WatsonSession) []WatsonSession {
+	streamable := make([]WatsonSession, 0, len(sessions))
+	for _, ws := range sessions {
+		if validLink(ws.Links.Session) {
+			streamable = append(streamable, ws)
+		}
+	}
+	log.Printf("Dropped %d of %d sessions which have no valid stream link", len(sessions)-len(streamable), len(sessions))
+	return streamable
+}
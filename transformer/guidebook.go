@@ -1,13 +1,29 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -35,12 +51,27 @@ type GuidebookSession struct {
 	ModeratorNotes      string  `json:"moderator_notes"`
 	Locations           []int   `json:"locations"`
 	ScheduleTracks      []int   `json:"schedule_tracks"`
+	guide               string  // Which guide it came from, when there are several
 }
 
 // 2017-08-31T20:18:28.038556+0000
 const GUIDEBOOK_TIME_FORMAT string = "2006-01-02T15:04:05.999999+0000"
 
-const GUESTS_OF_HONOR_ID = 1153959
+// 2017-08-31T13:18:28.038556-0700
+const GUIDEBOOK_OFFSET_TIME_FORMAT string = "2006-01-02T15:04:05.999999-0700"
+
+// parseGuidebookTime parses a Guidebook timestamp, which is normally in UTC but
+// may carry some other offset.
+func parseGuidebookTime(value string) (time.Time, error) {
+	t, err := time.Parse(GUIDEBOOK_TIME_FORMAT, value)
+	if err == nil {
+		return t, nil
+	}
+	if t, offsetErr := time.Parse(GUIDEBOOK_OFFSET_TIME_FORMAT, value); offsetErr == nil {
+		return t, nil
+	}
+	return t, err
+}
 
 // GuidebookLocation represents a location for a session.
 type GuidebookLocation struct {
@@ -50,24 +81,28 @@ type GuidebookLocation struct {
 
 // ScheduleTrack represents a track for a session.
 type ScheduleTrack struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description"`
 }
 
 type CustomList struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Items []int  `json:"items,omitempty"`
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Items    []int  `json:"items,omitempty"`
+	SubLists []int  `json:"sub_lists,omitempty"`
 }
 
 type ListItem struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Subtitle    string `json:"subtitle"`
-	Thumbnail   string `json:"thumbnail"`
-	Descripion  string `json:"description_html"`
-	CustomLists []int  `json:"custom_lists"`
-	Image       string `json:"image"`
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Subtitle    string  `json:"subtitle"`
+	Thumbnail   string  `json:"thumbnail"`
+	Descripion  string  `json:"description_html"`
+	CustomLists []int   `json:"custom_lists"`
+	Image       string  `json:"image"`
+	Rank        float64 `json:"rank"`
 }
 
 type CatLink struct {
@@ -82,6 +117,18 @@ type CatLink struct {
 	Category   any     `json:"category_detail"`
 }
 
+// CategoryName returns the name of the link category from the category_detail, if Guidebook gave us one
+func (cl CatLink) CategoryName() string {
+	switch detail := cl.Category.(type) {
+	case map[string]any:
+		name, _ := detail["name"].(string)
+		return name
+	case string:
+		return detail
+	}
+	return ""
+}
+
 type ListCategory struct {
 	ID    int       `json:"id"`
 	Name  string    `json:"name"`
@@ -91,12 +138,15 @@ type ListCategory struct {
 type SessionLink struct {
 	TargetType string `json:"target_content_type"`
 	TargetID   int    `json:"target_object_id"`
+	Category   string `json:"category,omitempty"`
 }
 
+// People are list items, and streams are webviews, as far as the content types go: see
+// GuideBook.isPersonItem and WatsonSession.linkStreamWebViews for how we tell them apart.
 const GB_TARGET_TYPE_LISTITEM = "custom_list.customlistitem"
 const GB_TARGET_TYPE_WEBVIEW = "uri_resource.webview"
-const GB_TARGET_TYPE_PERSON = "custom_list.customlistitem"
-const GB_TARGET_TYPE_STREAM = "uri_resource.webview"
+const GB_TARGET_TYPE_SESSION = "schedule.session"
+const GB_TARGET_TYPE_LIST = "custom_list.customlist"
 
 type SessionList struct {
 	SessionID int                 `json:"id"`
@@ -113,90 +163,342 @@ type WebView struct {
 
 // GuideBook a structure with everything we know from the guidebook
 type GuideBook struct {
-	config        conf                `json:"-"`
-	Sessions      []GuidebookSession  `json:"sessions"`
-	Locations     map[int]string      `json:"locations"`
-	SessionLinks  map[int]SessionList `json:"session_links"`
-	OtherLinks    map[int][]CatLink   `json:"other_links"`
-	Lists         map[int]CustomList  `json:"custom_lists"`
-	ListItems     map[int]ListItem    `json:"custom_list_items"`
-	Tracks        map[int]string      `json:"tracks"`
-	GuestsOfHonor map[int]string      `json:"guests_of_honor"`
-	WebViews      map[int]WebView     `json:"webviews"`
+	config        conf                  `json:"-"`
+	Metrics       FetchMetrics          `json:"metrics,omitempty"`
+	client        *http.Client          `json:"-"`
+	Sessions      []GuidebookSession    `json:"sessions"`
+	Locations     map[int]string        `json:"locations"`
+	SessionLinks  map[int]SessionList   `json:"session_links"`
+	OtherLinks    map[int][]CatLink     `json:"other_links"`
+	Relationships []ResolvedLink        `json:"relationships,omitempty"` // The OtherLinks resolved, only for -dump
+	Lists         map[int]CustomList    `json:"custom_lists"`
+	ListItems     map[int]ListItem      `json:"custom_list_items"`
+	Tracks        map[int]ScheduleTrack `json:"tracks"`
+	GuestsOfHonor map[int]string        `json:"guests_of_honor"`
+	WebViews      map[int]WebView       `json:"webviews"`
+	Overrides     Overrides             `json:"overrides"`
 }
 
-var guideBookRequestCounter = 0
-
-func loadGuidebook(c conf) (gb GuideBook, err error) {
+// loadGuidebook fetches everything from the guide, or from each of the guides when GB_ID is a
+// comma-separated list of them, merged together as if they were one guide.
+func loadGuidebook(ctx context.Context, c conf) (gb GuideBook, err error) {
 	gb.config = c
-	if err = gb.FetchSessions(); err != nil {
-		return gb, fmt.Errorf("failed to load sessions from GuideBook: %w", err)
+	// With its own transport, so that its connections are only reused by its own requests
+	gb.client = &http.Client{Timeout: c.RequestTimeout, Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	gb.Metrics = make(FetchMetrics)
+
+	guideIDs := splitList(c.GuidebookID)
+	if len(guideIDs) <= 1 {
+		if err = gb.fetchGuide(ctx); err != nil {
+			return gb, err
+		}
+	} else {
+		for _, guideID := range guideIDs {
+			one := GuideBook{config: c, client: gb.client, Metrics: gb.Metrics}
+			one.config.GuidebookID = guideID
+			// Each guide's raw files go in their own directory, since their names would be the same
+			if c.LocalDataDir != "" {
+				one.config.LocalDataDir = filepath.Join(c.LocalDataDir, guideID)
+			}
+			if c.DumpRawDir != "" {
+				one.config.DumpRawDir = filepath.Join(c.DumpRawDir, guideID)
+				if err = os.MkdirAll(one.config.DumpRawDir, 0755); err != nil {
+					return gb, fmt.Errorf("unable to create directory %q for raw dumps: %w", one.config.DumpRawDir, err)
+				}
+			}
+			log.Printf("Fetching guide %s", guideID)
+			if err = one.fetchGuide(ctx); err != nil {
+				return gb, fmt.Errorf("guide %s: %w", guideID, err)
+			}
+			if err = gb.merge(one); err != nil {
+				return gb, fmt.Errorf("unable to merge guide %s: %w", guideID, err)
+			}
+		}
+	}
+
+	if gb.Overrides, err = loadOverrides(c.OverridesPath); err != nil {
+		return gb, err
+	}
+
+	gb.GuestsOfHonor = make(map[int]string)
+	unnamed := make([]int, 0)
+	gohList, exists := gb.Lists[c.GuestsOfHonorListID]
+	if !exists {
+		log.Printf("There is no Guests of Honor list %d in the guide, so nobody will be a Guest of Honor", c.GuestsOfHonorListID)
+	}
+	for _, goh := range gohList.Items {
+		gb.GuestsOfHonor[goh] = gb.ListItems[goh].Name
+		if strings.TrimSpace(gb.GuestsOfHonor[goh]) == "" {
+			unnamed = append(unnamed, goh)
+		}
 	}
+	if len(unnamed) > 0 {
+		sort.Ints(unnamed)
+		log.Printf("There are %d Guests of Honor without a name: %v", len(unnamed), unnamed)
+		if c.Strict {
+			return gb, fmt.Errorf("%d Guests of Honor have no name", len(unnamed))
+		}
+	}
+
+	// After the Guests of Honor, who are only those directly on their list, and not everything on a
+	// list (like a bibliography) which one of them links to
+	gb.ResolveNestedLists(c.ListNestingDepth)
 
-	if err = gb.FetchLocations(); err != nil {
-		return gb, fmt.Errorf("failed to load session locations from GuideBook: %w", err)
+	gb.Metrics.Log()
+	return gb, nil
+}
+
+// fetchGuide fetches everything we need from the one guide in gb.config.GuidebookID
+func (gb *GuideBook) fetchGuide(ctx context.Context) (err error) {
+	if err = gb.FetchSessions(ctx); err != nil {
+		return fmt.Errorf("failed to load sessions from GuideBook: %w", err)
+	}
+	for i := range gb.Sessions {
+		gb.Sessions[i].guide = gb.config.GuidebookID
 	}
 
-	if err = gb.FetchTracks(); err != nil {
-		return gb, fmt.Errorf("failed to load schedule tracks from GuideBook: %w", err)
+	if err = gb.FetchLocations(ctx); err != nil {
+		return fmt.Errorf("failed to load session locations from GuideBook: %w", err)
 	}
 
-	if err = gb.FetchLists(); err != nil {
-		return gb, fmt.Errorf("failed to load lists and listitems from GuideBook: %w", err)
+	if err = gb.FetchTracks(ctx); err != nil {
+		return fmt.Errorf("failed to load schedule tracks from GuideBook: %w", err)
 	}
 
-	if err = gb.FetchSessionLinks(); err != nil {
-		return gb, fmt.Errorf("failed to load session links from GuideBook: %w", err)
+	if err = gb.FetchLists(ctx); err != nil {
+		return fmt.Errorf("failed to load lists and listitems from GuideBook: %w", err)
 	}
 
-	// err = gb.FetchWebViews()
+	if err = gb.FetchSessionLinks(ctx); err != nil {
+		return fmt.Errorf("failed to load session links from GuideBook: %w", err)
+	}
 
-	gb.GuestsOfHonor = make(map[int]string)
-	for _, goh := range gb.Lists[GUESTS_OF_HONOR_ID].Items {
-		gb.GuestsOfHonor[goh] = gb.ListItems[goh].Name
+	if err = gb.FetchWebViews(ctx); err != nil {
+		return fmt.Errorf("failed to load webviews from GuideBook: %w", err)
 	}
+	return nil
+}
 
-	return gb, nil
+// merge adds everything from another guide into this one.  IDs in Guidebook are meant to be
+// unique across guides, so if the same ID turns up in both for anything we refuse to merge
+// rather than have one guide quietly clobber the other.
+func (gb *GuideBook) merge(other GuideBook) error {
+	sessionIDs := make(map[int]bool, len(gb.Sessions))
+	for _, gs := range gb.Sessions {
+		sessionIDs[gs.ID] = true
+	}
+	for _, gs := range other.Sessions {
+		if sessionIDs[gs.ID] {
+			return fmt.Errorf("session %d is in more than one guide", gs.ID)
+		}
+	}
+	gb.Sessions = append(gb.Sessions, other.Sessions...)
+
+	var err error
+	if gb.Locations, err = mergeByID(gb.Locations, other.Locations, "location"); err != nil {
+		return err
+	}
+	if gb.Tracks, err = mergeByID(gb.Tracks, other.Tracks, "track"); err != nil {
+		return err
+	}
+	if gb.Lists, err = mergeByID(gb.Lists, other.Lists, "custom list"); err != nil {
+		return err
+	}
+	if gb.ListItems, err = mergeByID(gb.ListItems, other.ListItems, "custom list item"); err != nil {
+		return err
+	}
+	if gb.SessionLinks, err = mergeByID(gb.SessionLinks, other.SessionLinks, "session's links"); err != nil {
+		return err
+	}
+	if gb.WebViews, err = mergeByID(gb.WebViews, other.WebViews, "webview"); err != nil {
+		return err
+	}
+	if gb.OtherLinks == nil {
+		gb.OtherLinks = make(map[int][]CatLink)
+	}
+	for id, links := range other.OtherLinks {
+		gb.OtherLinks[id] = append(gb.OtherLinks[id], links...)
+	}
+	return nil
+}
+
+// mergeByID adds everything in from to into, unless they have an ID in common
+func mergeByID[V any](into, from map[int]V, what string) (map[int]V, error) {
+	if into == nil {
+		into = make(map[int]V, len(from))
+	}
+	for id, value := range from {
+		if _, exists := into[id]; exists {
+			return into, fmt.Errorf("%s %d is in more than one guide", what, id)
+		}
+		into[id] = value
+	}
+	return into, nil
 }
 
-func multiFetch(c conf, fetchWhat string) ([]byte, error) {
+// fetchResource returns the JSON for everything of one kind in the guide, either from the
+// API or, when LOCAL_DATA_DIR is set, from the <resource>.raw.json that --dump-raw wrote there.
+func (gb *GuideBook) fetchResource(ctx context.Context, fetchWhat string) ([]byte, error) {
+	c := gb.config
+	if c.LocalDataDir == "" {
+		started := time.Now()
+		metrics := gb.Metrics.For(fetchWhat)
+		defer func() { metrics.Duration += time.Since(started) }()
+		return multiFetch(ctx, gb.client, c, fetchWhat, metrics)
+	}
+	localPath := filepath.Join(c.LocalDataDir, fetchWhat+".raw.json")
+	response, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local %s: %w", fetchWhat, err)
+	}
+	log.Printf("Read %s from %q", fetchWhat, localPath)
+	return response, nil
+}
+
+// multiFetch fetches every page of a resource from the API, using client for every request
+// so that the connection is reused, and counting the requests and bytes in metrics.
+func multiFetch(ctx context.Context, client *http.Client, c conf, fetchWhat string, metrics *EndpointMetrics) ([]byte, error) {
 	var allResults []any
-	client := &http.Client{}
 
-	nextURL := fmt.Sprintf("https://builder.guidebook.com/open-api/v1.1/%s/?guide=%s", fetchWhat, c.GuidebookID)
+	nextURL := fmt.Sprintf("%s/%s/?guide=%s", strings.TrimRight(c.GuidebookBaseURL, "/"), fetchWhat, url.QueryEscape(c.GuidebookID))
+	if c.Since != "" {
+		nextURL += "&updated_at__gte=" + url.QueryEscape(c.Since)
+	}
 
+	initialAttempt := 0
 	for nextURL != "" {
+		attempt := 0
+		reauthenticated := false
+		rateLimited := 0
+		tried := false
 	retryAfterWait:
-		req, err := http.NewRequest("GET", nextURL, nil)
+		if err := c.Budget.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("gave up fetching %s: %w", fetchWhat, err)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request for %s: %w", fetchWhat, err)
 		}
 
-		req.Header.Set("Authorization", "JWT "+c.GuidebookAPIKey)
+		if c.OAuth != nil {
+			token, err := c.OAuth.Token(client)
+			if err != nil {
+				return nil, fmt.Errorf("failed to authenticate for %s: %w", fetchWhat, err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.Header.Set("Authorization", "JWT "+c.GuidebookAPIKey)
+		}
 
+		metrics.Requests++
+		if tried {
+			metrics.Retries++
+		}
+		tried = true
 		resp, err := client.Do(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("gave up fetching %s: %w", fetchWhat, ctx.Err())
+			}
+			if isTransientError(err) && c.Budget.Requests() == 0 && initialAttempt < c.InitialRetries {
+				// Nothing has worked yet, so perhaps we started before the network was up
+				initialAttempt++
+				wait := retryBackoff(c, initialAttempt)
+				log.Printf("Waiting for the network: the first request for %s failed (%s), connection attempt %d of %d in %s...", fetchWhat, err.Error(), initialAttempt, c.InitialRetries, wait)
+				if err := sleep(ctx, wait); err != nil {
+					return nil, fmt.Errorf("gave up fetching %s: %w", fetchWhat, err)
+				}
+				goto retryAfterWait
+			}
+			if isTransientError(err) && attempt < c.MaxRetries {
+				attempt++
+				wait := retryBackoff(c, attempt)
+				log.Printf("Request %d for %s failed (%s), retry %d of %d in %s...", c.Budget.Requests()+1, fetchWhat, err.Error(), attempt, c.MaxRetries, wait)
+				if err := sleep(ctx, wait); err != nil {
+					return nil, fmt.Errorf("gave up fetching %s: %w", fetchWhat, err)
+				}
+				goto retryAfterWait
+			}
 			return nil, fmt.Errorf("failed to execute request for %s: %w", fetchWhat, err)
 		}
-		bodyBytes, _ := io.ReadAll(resp.Body)
+		bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, c.MaxResponseBytes+1))
 		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response for %s: %w", fetchWhat, err)
+		}
+		if len(bytes.TrimSpace(bodyBytes)) > 0 && int64(len(bodyBytes)) <= c.MaxResponseBytes {
+			// An empty body has nothing to decompress, even when it says it's compressed, and
+			// is retried below like any other
+			body, err := decodedBody(resp.Header.Get("Content-Encoding"), bytes.NewReader(bodyBytes))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %s response for %s: %w", resp.Header.Get("Content-Encoding"), fetchWhat, err)
+			}
+			if bodyBytes, err = io.ReadAll(io.LimitReader(body, c.MaxResponseBytes+1)); err != nil {
+				return nil, fmt.Errorf("failed to read response for %s: %w", fetchWhat, err)
+			}
+		}
+		metrics.Bytes += int64(len(bodyBytes))
+		if int64(len(bodyBytes)) > c.MaxResponseBytes {
+			return nil, fmt.Errorf("response for %s is larger than the maximum of %d bytes", fetchWhat, c.MaxResponseBytes)
+		}
 
 		if resp.StatusCode != http.StatusOK {
+			if resp.StatusCode == http.StatusUnauthorized && c.OAuth != nil && !reauthenticated {
+				// The token may have been revoked before its expiry, so get a fresh one and try again
+				log.Printf("Request %d for %s was unauthorized, fetching a new OAuth token", c.Budget.Requests()+1, fetchWhat)
+				c.OAuth.Invalidate()
+				reauthenticated = true
+				goto retryAfterWait
+			}
+			if resp.StatusCode >= 500 && resp.StatusCode <= 599 && attempt < c.MaxRetries {
+				// Guidebook gives us the odd 502 or 503 while it's being deployed
+				attempt++
+				wait := retryBackoff(c, attempt)
+				log.Printf("Request %d for %s failed with status %s, retry %d of %d in %s...", c.Budget.Requests()+1, fetchWhat, resp.Status, attempt, c.MaxRetries, wait)
+				if err := sleep(ctx, wait); err != nil {
+					return nil, fmt.Errorf("gave up fetching %s: %w", fetchWhat, err)
+				}
+				goto retryAfterWait
+			}
 			if resp.StatusCode == 429 {
-				retryWait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
-				if retryWait > 0 {
-					log.Printf("We got a 429 on request %d and are now waiting for %d seconds before our next request...", guideBookRequestCounter+1, retryWait)
-					time.Sleep(time.Duration(1+retryWait) * time.Second)
-					goto retryAfterWait
+				if rateLimited >= c.RateLimitRetries {
+					log.Println("Well, we got rate limited.  Here's the headers...")
+					for key, value := range resp.Header {
+						log.Printf("%s: %s", key, value)
+					}
+					return nil, fmt.Errorf("guidebook API request for %s was still rate limited after %d retries", fetchWhat, rateLimited)
 				}
-				log.Println("Well, we got rate limited.  Here's the headers...")
-				for key, value := range resp.Header {
-					log.Printf("%s: %s", key, value)
+				rateLimited++
+				if retryWait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+					log.Printf("We got a 429 on request %d and are now waiting for %s before our next request...", c.Budget.Requests()+1, retryWait.Round(time.Second))
+					c.Budget.PauseFor(retryWait)
+					goto retryAfterWait
 				}
+				wait := rateLimitBackoff(c, rateLimited)
+				log.Printf("We got a 429 on request %d without a Retry-After, so backing off for %s (retry %d of %d)...", c.Budget.Requests()+1, wait.Round(time.Millisecond), rateLimited, c.RateLimitRetries)
+				c.Budget.PauseFor(wait)
+				goto retryAfterWait
 			}
 			return nil, fmt.Errorf("guidebook API request for %s failed with status %s: %s", fetchWhat, resp.Status, string(bodyBytes))
 		}
-		guideBookRequestCounter++ // Only successful ones count
+		if len(bytes.TrimSpace(bodyBytes)) == 0 {
+			// A flaky gateway will occasionally give us a 200 with nothing in it
+			if attempt < c.MaxRetries {
+				attempt++
+				wait := retryBackoff(c, attempt)
+				log.Printf("Request %d for %s returned an empty body, retry %d of %d in %s...", c.Budget.Requests()+1, fetchWhat, attempt, c.MaxRetries, wait)
+				if err := sleep(ctx, wait); err != nil {
+					return nil, fmt.Errorf("gave up fetching %s: %w", fetchWhat, err)
+				}
+				goto retryAfterWait
+			}
+			return nil, fmt.Errorf("guidebook API request for %s returned an empty body %d times", fetchWhat, attempt+1)
+		}
+		c.Budget.Succeeded() // Only successful ones count
+		if c.SlowDown > 0 {
+			// Keep under Guidebook's rate limit, rather than waiting to be told we're over it
+			c.Budget.PauseFor(c.SlowDown)
+		}
 
 		var response MultiResponse
 		if err := json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&response); err != nil {
@@ -205,19 +507,152 @@ func multiFetch(c conf, fetchWhat string) ([]byte, error) {
 		}
 
 		allResults = append(allResults, response.Results...)
-		nextURL = response.Next
+		nextURL, err = resolveNext(nextURL, response.Next)
+		if err != nil {
+			return nil, fmt.Errorf("bad next page for %s: %w", fetchWhat, err)
+		}
 	}
 
-	log.Printf("Fetched %s chain - %d requests so far.", fetchWhat, guideBookRequestCounter)
+	log.Printf("Fetched %s chain - %d requests so far.", fetchWhat, c.Budget.Requests())
+
+	results, err := json.Marshal(allResults)
+	if err != nil {
+		return nil, err
+	}
+	if c.DumpRawDir != "" {
+		// Keep exactly what the API gave us, before any joining, so we can tell the two apart
+		rawPath := filepath.Join(c.DumpRawDir, fetchWhat+".raw.json")
+		if c.Since != "" {
+			if results, err = mergeSnapshot(rawPath, results); err != nil {
+				return nil, fmt.Errorf("failed to merge %s updated since %s: %w", fetchWhat, c.Since, err)
+			}
+			log.Printf("Merged %d %s updated since %s into %q", len(allResults), fetchWhat, c.Since, rawPath)
+		}
+		if err := writeBytesAtomic(rawPath, results); err != nil {
+			return nil, fmt.Errorf("failed to write raw %s to %q: %w", fetchWhat, rawPath, err)
+		}
+	}
+	return results, nil
+}
 
-	return json.Marshal(allResults)
+// resolveNext works out the URL of the next page from the one we just fetched.  Usually "next"
+// is absolute, and it may well be on a different host from GB_BASE_URL, which is fine, but
+// a relative one is taken relative to the current page.
+func resolveNext(current, next string) (string, error) {
+	if next == "" {
+		return "", nil
+	}
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// sleep is how we wait between requests, which is sleepContext unless a test would rather not
+var sleep = sleepContext
+
+// sleepContext sleeps for d, or until ctx is done if that's sooner
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// decodedBody returns a response body, decompressed if it has a Content-Encoding.  Go only
+// does that for us when it asked for compression itself, which it won't have when a proxy
+// compresses regardless.
+func decodedBody(encoding string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// This should be zlib-wrapped, but some servers send raw deflate, so look before we leap
+		buffered := bufio.NewReader(body)
+		header, err := buffered.Peek(2)
+		if err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	}
+	return body, nil
+}
+
+// isTransientError reports whether an error from client.Do is a transport-level
+// problem (timeout, connection reset, DNS blip) that is worth retrying, as opposed
+// to something permanent like an unknown host, an invalid URL or an unsupported scheme.
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		// A host which doesn't exist (e.g. a mistyped GB_BASE_URL) won't start existing if we wait
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	// Any other network error is only worth retrying if it was a dial or read which timed out
+	// or had its connection reset
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return (opErr.Op == "dial" || opErr.Op == "read") && (opErr.Timeout() || errors.Is(opErr.Err, syscall.ECONNRESET))
+	}
+	return false
+}
+
+// retryBackoff returns how long to wait before retry number 'attempt' (starting at 1),
+// doubling the configured RetryDelay each time.
+func retryBackoff(c conf, attempt int) time.Duration {
+	return c.RetryDelay << (attempt - 1)
+}
+
+// parseRetryAfter works out how long a Retry-After header tells us to wait, which may be either
+// a number of seconds or an HTTP date.  We wait an extra second, to be sure we're not early.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(1+seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return max(0, when.Sub(now)) + time.Second, true
+	}
+	return 0, false
+}
+
+// rateLimitBackoff returns how long to back off before retry number 'attempt' (starting at 1)
+// when we've been rate limited without being told for how long.  It starts at a second and
+// doubles up to GB_RATE_LIMIT_MAX_DELAY, with some jitter so that several clients don't all
+// come back at once.
+func rateLimitBackoff(c conf, attempt int) time.Duration {
+	wait := c.RateLimitMaxDelay
+	if attempt < 32 && time.Second<<(attempt-1) < wait {
+		wait = time.Second << (attempt - 1)
+	}
+	return wait/2 + rand.N(wait/2+1)
 }
 
 // FetchSessions fetches all sessions from a specific guide in Guidebook.
 // It requires an API key and the ID of the guide.
 // It handles pagination automatically to retrieve all session records.
-func (gb *GuideBook) FetchSessions() error {
-	response, err := multiFetch(gb.config, "sessions")
+func (gb *GuideBook) FetchSessions(ctx context.Context) error {
+	response, err := gb.fetchResource(ctx, "sessions")
 	if err != nil {
 		return fmt.Errorf("failed to fetch results: %w", err)
 	}
@@ -230,9 +665,9 @@ func (gb *GuideBook) FetchSessions() error {
 }
 
 // FetchLocations fetches all locations from a specific guide in Guidebook.
-func (gb *GuideBook) FetchLocations() error {
+func (gb *GuideBook) FetchLocations(ctx context.Context) error {
 	allLocations := make([]GuidebookLocation, 0)
-	response, err := multiFetch(gb.config, "locations")
+	response, err := gb.fetchResource(ctx, "locations")
 	if err != nil {
 		return fmt.Errorf("failed to fetch results: %w", err)
 	}
@@ -248,10 +683,15 @@ func (gb *GuideBook) FetchLocations() error {
 	return nil
 }
 
+// TrackName is the name of the track, or "" if we don't know it
+func (gb GuideBook) TrackName(id int) string {
+	return gb.Tracks[id].Name
+}
+
 // FetchTracks fetches all schedule tracks from a specific guide in Guidebook.
-func (gb *GuideBook) FetchTracks() error {
+func (gb *GuideBook) FetchTracks(ctx context.Context) error {
 	allTracks := make([]ScheduleTrack, 0)
-	response, err := multiFetch(gb.config, "schedule-tracks")
+	response, err := gb.fetchResource(ctx, "schedule-tracks")
 	if err != nil {
 		return fmt.Errorf("failed to fetch results: %w", err)
 	}
@@ -259,18 +699,18 @@ func (gb *GuideBook) FetchTracks() error {
 		fmt.Println(string(response))
 		return fmt.Errorf("failed to decode guidebook response: %w", err)
 	}
-	gb.Tracks = make(map[int]string)
+	gb.Tracks = make(map[int]ScheduleTrack)
 	for _, v := range allTracks {
-		gb.Tracks[v.ID] = v.Name
+		gb.Tracks[v.ID] = v
 	}
 
 	return nil
 }
 
 // FetchLists fetches all custom-lists from a specific guide in Guidebook.
-func (gb *GuideBook) FetchLists() error {
+func (gb *GuideBook) FetchLists(ctx context.Context) error {
 	customLists := make([]CustomList, 0)
-	response, err := multiFetch(gb.config, "custom-lists")
+	response, err := gb.fetchResource(ctx, "custom-lists")
 	if err != nil {
 		return fmt.Errorf("failed to fetch results: %w", err)
 	}
@@ -285,7 +725,7 @@ func (gb *GuideBook) FetchLists() error {
 	}
 
 	allItems := make([]ListItem, 0, 1000)
-	response, err = multiFetch(gb.config, "custom-list-items")
+	response, err = gb.fetchResource(ctx, "custom-list-items")
 	if err != nil {
 		return fmt.Errorf("failed to fetch results: %w", err)
 	}
@@ -305,56 +745,86 @@ func (gb *GuideBook) FetchLists() error {
 			gb.Lists[w] = list
 		}
 	}
+	for _, list := range gb.Lists {
+		// In the order curated in Guidebook, rather than whatever order the map gave us
+		sort.Slice(list.Items, func(i, j int) bool {
+			return gb.listItemBefore(list.Items[i], list.Items[j])
+		})
+	}
 
 	return nil
 }
 
-// FetchSessionLinks fetches the link categories related to a session
-func (gb *GuideBook) ExFetchSessionLinks() error {
-	listCats := make([]ListCategory, 0)
-	response, err := multiFetch(gb.config, "link-categories")
-	if err != nil {
-		return fmt.Errorf("failed to fetch results: %w", err)
+// ResolveNestedLists finds the lists which are nested in another list, through an item of that
+// list which links to them, and brings the items of the nested lists up into the containing list,
+// down to depth levels of nesting.
+func (gb *GuideBook) ResolveNestedLists(depth int) {
+	if depth <= 0 {
+		return
 	}
-	if err := json.NewDecoder(bytes.NewReader(response)).Decode(&listCats); err != nil {
-		fmt.Println(string(response))
-		return fmt.Errorf("failed to decode guidebook response: %w", err)
+	direct := make(map[int][]int, len(gb.Lists))
+	children := make(map[int][]int)
+	for id, list := range gb.Lists {
+		direct[id] = slices.Clone(list.Items)
+		for _, item := range list.Items {
+			for _, link := range gb.OtherLinks[item] {
+				if link.SourceType != GB_TARGET_TYPE_LISTITEM || link.TargetType != GB_TARGET_TYPE_LIST {
+					continue
+				}
+				if _, exists := gb.Lists[link.TargetID]; exists && !slices.Contains(children[id], link.TargetID) {
+					children[id] = append(children[id], link.TargetID)
+				}
+			}
+		}
 	}
 
-	gb.OtherLinks = make(map[int][]CatLink)
-	gb.SessionLinks = make(map[int]SessionList)
-	for _, v := range listCats {
-		for _, w := range v.Links {
-			if w.SourceType == "schedule.session" {
-				list, exists := gb.SessionLinks[w.SourceID]
-				if !exists {
-					list = SessionList{
-						SessionID: w.SourceID,
-						TargetIDs: make(map[int]SessionLink, 0),
-					}
+	for id, list := range gb.Lists {
+		sort.Ints(children[id])
+		list.SubLists = children[id]
+
+		// Breadth first, and never into a list we've already been through, in case they nest in a loop
+		seen := map[int]bool{id: true}
+		items := slices.Clone(direct[id])
+		level := children[id]
+		for d := 0; d < depth && len(level) > 0; d++ {
+			next := make([]int, 0)
+			for _, child := range level {
+				if child == id {
+					log.Printf("List (%d, %s) is nested inside itself", id, list.Name)
 				}
-				list.TargetIDs[w.TargetID] = SessionLink{
-					TargetType: w.TargetType,
-					TargetID:   w.TargetID,
+				if seen[child] {
+					continue
 				}
-				gb.SessionLinks[w.SourceID] = list
-			} else {
-				list, exists := gb.OtherLinks[w.SourceID]
-				if !exists {
-					list = make([]CatLink, 0)
+				seen[child] = true
+				for _, item := range direct[child] {
+					if !slices.Contains(items, item) {
+						items = append(items, item)
+					}
 				}
-				list = append(list, w)
-				gb.OtherLinks[w.SourceID] = list
+				next = append(next, children[child]...)
 			}
+			level = next
 		}
+		sort.Slice(items, func(i, j int) bool {
+			return gb.listItemBefore(items[i], items[j])
+		})
+		list.Items = items
+		gb.Lists[id] = list
 	}
-	return nil
 }
 
-// FetchSessionLinks fetches the link categories related to a session
-func (gb *GuideBook) FetchSessionLinks() error {
-	listCats := make([]CatLink, 0)
-	response, err := multiFetch(gb.config, "links")
+// listItemBefore orders list items by their rank in Guidebook, and then by ID
+func (gb *GuideBook) listItemBefore(a, b int) bool {
+	if gb.ListItems[a].Rank != gb.ListItems[b].Rank {
+		return gb.ListItems[a].Rank < gb.ListItems[b].Rank
+	}
+	return a < b
+}
+
+// ExFetchSessionLinks fetches the links grouped under their link categories
+func (gb *GuideBook) ExFetchSessionLinks(ctx context.Context) error {
+	listCats := make([]ListCategory, 0)
+	response, err := gb.fetchResource(ctx, "link-categories")
 	if err != nil {
 		return fmt.Errorf("failed to fetch results: %w", err)
 	}
@@ -363,10 +833,50 @@ func (gb *GuideBook) FetchSessionLinks() error {
 		return fmt.Errorf("failed to decode guidebook response: %w", err)
 	}
 
+	gb.groupLinks(flattenCategories(listCats))
+	return nil
+}
+
+// flattenCategories pulls the links out of their categories, giving any link without its own
+// category_detail the category it came in, so it has the same role as it would in a flat list.
+func flattenCategories(listCats []ListCategory) []CatLink {
+	links := make([]CatLink, 0)
+	for _, v := range listCats {
+		for _, link := range v.Links {
+			if link.CategoryName() == "" {
+				link.CategoryID = cmp.Or(link.CategoryID, v.ID)
+				link.Category = map[string]any{"id": v.ID, "name": v.Name}
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// isCategoryShape reports whether a links response is actually a list of categories
+// with the links inside them, which some guides give us instead of the flat links.
+func isCategoryShape(response []byte) (bool, error) {
+	probe := make([]map[string]json.RawMessage, 0)
+	if err := json.Unmarshal(response, &probe); err != nil {
+		return false, err
+	}
+	for _, item := range probe {
+		if _, hasLinks := item["links"]; hasLinks {
+			return true, nil
+		}
+		if _, hasSource := item["source_object_id"]; hasSource {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// groupLinks splits the links into those from a session, grouped by the session, and all the others
+func (gb *GuideBook) groupLinks(links []CatLink) {
 	gb.OtherLinks = make(map[int][]CatLink)
 	gb.SessionLinks = make(map[int]SessionList)
-	for _, w := range listCats {
-		if w.SourceType == "schedule.session" {
+	for _, w := range links {
+		if w.SourceType == GB_TARGET_TYPE_SESSION {
 			list, exists := gb.SessionLinks[w.SourceID]
 			if !exists {
 				list = SessionList{
@@ -374,9 +884,16 @@ func (gb *GuideBook) FetchSessionLinks() error {
 					TargetIDs: make(map[int]SessionLink, 0),
 				}
 			}
-			list.TargetIDs[w.TargetID] = SessionLink{
-				TargetType: w.TargetType,
-				TargetID:   w.TargetID,
+			// Someone can be linked to the same session more than once, under different categories.
+			// They keep the role they were first linked with, unless a later one makes them a
+			// Guest of Honor.
+			existing, linked := list.TargetIDs[w.TargetID]
+			if !linked || (!isGuestOfHonorCategory(existing.Category) && isGuestOfHonorCategory(w.CategoryName())) {
+				list.TargetIDs[w.TargetID] = SessionLink{
+					TargetType: w.TargetType,
+					TargetID:   w.TargetID,
+					Category:   w.CategoryName(),
+				}
 			}
 			gb.SessionLinks[w.SourceID] = list
 		} else {
@@ -388,12 +905,143 @@ func (gb *GuideBook) FetchSessionLinks() error {
 			gb.OtherLinks[w.SourceID] = list
 		}
 	}
+}
+
+// isGuestOfHonorCategory reports whether a link category is for a Guest of Honor
+func isGuestOfHonorCategory(category string) bool {
+	normalised := strings.Join(strings.Fields(strings.ToLower(category)), " ")
+	return normalised == "guest of honor" || normalised == "guest of honour"
+}
+
+// FetchSessionLinks fetches the links, which may come to us flat or wrapped in their categories
+func (gb *GuideBook) FetchSessionLinks(ctx context.Context) error {
+	response, err := gb.fetchResource(ctx, "links")
+	if err != nil {
+		return fmt.Errorf("failed to fetch results: %w", err)
+	}
+	categoryShape, err := isCategoryShape(response)
+	if err != nil {
+		fmt.Println(string(response))
+		return fmt.Errorf("failed to decode guidebook response: %w", err)
+	}
+
+	links := make([]CatLink, 0)
+	if categoryShape {
+		log.Println("The links came wrapped in their categories")
+		listCats := make([]ListCategory, 0)
+		if err := json.NewDecoder(bytes.NewReader(response)).Decode(&listCats); err != nil {
+			fmt.Println(string(response))
+			return fmt.Errorf("failed to decode guidebook response: %w", err)
+		}
+		links = flattenCategories(listCats)
+	} else if err := json.NewDecoder(bytes.NewReader(response)).Decode(&links); err != nil {
+		fmt.Println(string(response))
+		return fmt.Errorf("failed to decode guidebook response: %w", err)
+	}
+
+	gb.groupLinks(links)
 	return nil
 }
 
+// LinksToSessions indexes the OtherLinks which point at a session by that session's ID
+func (gb *GuideBook) LinksToSessions() map[int][]CatLink {
+	toSessions := make(map[int][]CatLink)
+	for _, links := range gb.OtherLinks {
+		for _, link := range links {
+			if link.TargetType == GB_TARGET_TYPE_SESSION {
+				toSessions[link.TargetID] = append(toSessions[link.TargetID], link)
+			}
+		}
+	}
+	return toSessions
+}
+
+// ResolvedLink is a link between two things in the guide, other than one from a session, with
+// the names of both ends so that a human can read it
+type ResolvedLink struct {
+	SourceType string `json:"source_type"`
+	SourceID   int    `json:"source_id"`
+	SourceName string `json:"source_name,omitempty"`
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	TargetName string `json:"target_name,omitempty"`
+	Category   string `json:"category,omitempty"`
+}
+
+// objectName is the name of a list item (such as a person), custom list, session or webview.
+// Other types of thing, and things we don't have, have no name.
+func (gb GuideBook) objectName(contentType string, id int) string {
+	switch contentType {
+	case GB_TARGET_TYPE_LISTITEM:
+		return gb.ListItems[id].Name
+	case GB_TARGET_TYPE_LIST:
+		return gb.Lists[id].Name
+	case GB_TARGET_TYPE_WEBVIEW:
+		return gb.WebViews[id].Name
+	case GB_TARGET_TYPE_SESSION:
+		for _, gs := range gb.Sessions {
+			if gs.ID == id {
+				return gs.Name
+			}
+		}
+	}
+	return ""
+}
+
+func (gb GuideBook) resolveLink(link CatLink) ResolvedLink {
+	return ResolvedLink{
+		SourceType: link.SourceType,
+		SourceID:   link.SourceID,
+		SourceName: gb.objectName(link.SourceType, link.SourceID),
+		TargetType: link.TargetType,
+		TargetID:   link.TargetID,
+		TargetName: gb.objectName(link.TargetType, link.TargetID),
+		Category:   link.CategoryName(),
+	}
+}
+
+// LinksFrom resolves the OtherLinks from one thing, such as a person's links to other people or
+// to lists.  OtherLinks is only keyed by the source's ID, so sourceType picks out the right ones.
+func (gb GuideBook) LinksFrom(sourceType string, sourceID int) []ResolvedLink {
+	resolved := make([]ResolvedLink, 0)
+	for _, link := range gb.OtherLinks[sourceID] {
+		if link.SourceType == sourceType {
+			resolved = append(resolved, gb.resolveLink(link))
+		}
+	}
+	return resolved
+}
+
+// ResolveOtherLinks resolves all of the OtherLinks, which are the links from anything but a
+// session: from list items (people) and custom lists to other list items, lists, sessions and
+// webviews.  Those are the types which get names; any other type is passed through by its ID.
+// They are sorted by their source and then their target.
+func (gb GuideBook) ResolveOtherLinks() []ResolvedLink {
+	resolved := make([]ResolvedLink, 0)
+	for _, links := range gb.OtherLinks {
+		for _, link := range links {
+			resolved = append(resolved, gb.resolveLink(link))
+		}
+	}
+	sort.Slice(resolved, func(i, j int) bool {
+		a, b := resolved[i], resolved[j]
+		if a.SourceType != b.SourceType {
+			return a.SourceType < b.SourceType
+		}
+		if a.SourceID != b.SourceID {
+			return a.SourceID < b.SourceID
+		}
+		if a.TargetType != b.TargetType {
+			return a.TargetType < b.TargetType
+		}
+		return a.TargetID < b.TargetID
+	})
+	return resolved
+}
+
 // FetchWebViews fetches the webviews related to a session
-func (gb *GuideBook) FetchWebViews() error {
-	response, err := multiFetch(gb.config, "webviews")
+func (gb *GuideBook) FetchWebViews(ctx context.Context) error {
+	response, err := gb.fetchResource(ctx, "webviews")
 	if err != nil {
 		return fmt.Errorf("failed to fetch webviews results: %w", err)
 	}
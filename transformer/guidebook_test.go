@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseGuidebookTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"2017-08-31T20:18:28.038556+0000", "2017-08-31T20:18:28.038556Z", false},
+		{"2017-08-31T20:18:28+0000", "2017-08-31T20:18:28Z", false},
+		{"2017-08-31T13:18:28.038556-0700", "2017-08-31T13:18:28.038556-07:00", false},
+		{"2025-08-14T10:00:00+1200", "2025-08-14T10:00:00+12:00", false},
+		{"2017-08-31 20:18:28", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseGuidebookTime(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseGuidebookTime(%q) = %s, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGuidebookTime(%q) failed: %s", tt.value, err)
+			continue
+		}
+		if formatted := got.Format(time.RFC3339Nano); formatted != tt.want {
+			t.Errorf("parseGuidebookTime(%q) = %s, want %s", tt.value, formatted, tt.want)
+		}
+	}
+}
+
+// timeoutError is a net.Error which timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", timeoutError{}, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "guidebook.invalid", IsNotFound: true}, false},
+		{"temporary DNS failure", &net.DNSError{Err: "server misbehaving", Name: "builder.guidebook.com", IsTemporary: true}, true},
+		{"DNS timeout", &net.DNSError{Err: "i/o timeout", Name: "builder.guidebook.com", IsTimeout: true}, true},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, true},
+		{"read reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"write failed", &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken")}, false},
+		{"dial failed", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("network is unreachable")}, false},
+		{"something else", errors.New("unsupported protocol scheme"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("isTransientError(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testFetchConf is enough configuration for multiFetch to fetch from baseURL without waiting long
+func testFetchConf(baseURL string) conf {
+	return conf{
+		GuidebookBaseURL:  baseURL,
+		GuidebookID:       "1234",
+		GuidebookAPIKey:   "key",
+		Budget:            &RateBudget{},
+		MaxRetries:        2,
+		RetryDelay:        time.Millisecond,
+		RateLimitRetries:  2,
+		RateLimitMaxDelay: 2 * time.Millisecond,
+		MaxResponseBytes:  1 << 20,
+	}
+}
+
+// resetOnce is a transport which has its first connection reset, and then works
+type resetOnce struct {
+	next  http.RoundTripper
+	tried bool
+}
+
+func (ro *resetOnce) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !ro.tried {
+		ro.tried = true
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	}
+	return ro.next.RoundTrip(req)
+}
+
+func TestMultiFetchConnectionReset(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"count": 1, "next": null, "results": [{"id": 1}]}`)
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	client.Transport = &resetOnce{next: client.Transport}
+	var metrics EndpointMetrics
+	body, err := multiFetch(context.Background(), client, testFetchConf(server.URL), "sessions", &metrics)
+	if err != nil {
+		t.Fatalf("multiFetch failed: %s", err)
+	}
+	var results []struct{ ID int }
+	if err := json.Unmarshal(body, &results); err != nil || len(results) != 1 || results[0].ID != 1 {
+		t.Errorf("multiFetch gave %s, want the one session", body)
+	}
+	if requests != 1 || metrics.Requests != 2 || metrics.Retries != 1 {
+		t.Errorf("server had %d requests, counted %d with %d retries, want 1, 2 and 1", requests, metrics.Requests, metrics.Retries)
+	}
+}
+
+func TestMultiFetchEmptyBody(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			fmt.Fprint(w, " \n")
+			return
+		}
+		fmt.Fprint(w, `{"count": 1, "next": null, "results": [{"id": 1}]}`)
+	}))
+	defer server.Close()
+
+	var metrics EndpointMetrics
+	body, err := multiFetch(context.Background(), server.Client(), testFetchConf(server.URL), "sessions", &metrics)
+	if err != nil {
+		t.Fatalf("multiFetch failed: %s", err)
+	}
+	if string(body) != `[{"id":1}]` {
+		t.Errorf("multiFetch gave %s, want the one session", body)
+	}
+	if requests != 2 || metrics.Retries != 1 {
+		t.Errorf("made %d requests with %d retries, want 2 and 1", requests, metrics.Retries)
+	}
+}
+
+func TestMultiFetchRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // For each request in turn, after which they all succeed
+		wantErr      bool
+		wantRequests int
+	}{
+		{"first time", nil, false, 2},
+		{"server errors", []int{http.StatusBadGateway, http.StatusServiceUnavailable}, false, 4},
+		{"too many server errors", []int{500, 502, 503}, true, 3},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, false, 4},
+		{"rate limited too often", []int{429, 429, 429}, true, 3},
+		{"not found", []int{http.StatusNotFound}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				n := requests
+				mu.Unlock()
+				if got := r.Header.Get("Authorization"); got != "JWT key" {
+					t.Errorf("request %d had Authorization %q", n, got)
+				}
+				if n <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[n-1])
+					return
+				}
+				// Two pages, the second given relative to the first
+				if r.URL.Query().Get("page") == "" {
+					fmt.Fprint(w, `{"count": 2, "next": "?guide=1234&page=2", "results": [{"id": 1}]}`)
+				} else {
+					fmt.Fprint(w, `{"count": 2, "next": null, "results": [{"id": 2}]}`)
+				}
+			}))
+			defer server.Close()
+
+			c := testFetchConf(server.URL)
+			var metrics EndpointMetrics
+			body, err := multiFetch(context.Background(), server.Client(), c, "sessions", &metrics)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("multiFetch gave %s, want an error", body)
+				}
+			} else if err != nil {
+				t.Fatalf("multiFetch failed: %s", err)
+			} else {
+				var results []struct{ ID int }
+				if err := json.Unmarshal(body, &results); err != nil {
+					t.Fatalf("multiFetch gave %s: %s", body, err)
+				}
+				if len(results) != 2 || results[0].ID != 1 || results[1].ID != 2 {
+					t.Errorf("multiFetch gave %s, want both pages", body)
+				}
+			}
+			if requests != tt.wantRequests || metrics.Requests != tt.wantRequests {
+				t.Errorf("made %d requests, counted %d, want %d", requests, metrics.Requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 8, 14, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"30", 31 * time.Second, true},
+		{" 5 ", 6 * time.Second, true},
+		{"0", 0, false},
+		{"-3", 0, false},
+		{"Thu, 14 Aug 2025 10:00:10 GMT", 11 * time.Second, true},
+		{"Thu, 14 Aug 2025 09:59:00 GMT", time.Second, true}, // Already past
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %t, want %s, %t", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestMultiFetchRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter string
+		min, max   time.Duration // How long it should wait, give or take how long the request took
+	}{
+		{"1", time.Second, 2 * time.Second},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 9 * time.Second, 11 * time.Second},
+	}
+	for _, tt := range tests {
+		var slept []time.Duration
+		sleep = func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return ctx.Err()
+		}
+		t.Cleanup(func() { sleep = sleepContext })
+
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", tt.retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, `{"count": 0, "next": null, "results": []}`)
+		}))
+		defer server.Close()
+
+		var metrics EndpointMetrics
+		if _, err := multiFetch(context.Background(), server.Client(), testFetchConf(server.URL), "sessions", &metrics); err != nil {
+			t.Fatalf("multiFetch failed: %s", err)
+		}
+		if len(slept) != 1 || slept[0] <= tt.min || slept[0] > tt.max {
+			t.Errorf("told to retry after %q, waited %v, want once for between %s and %s", tt.retryAfter, slept, tt.min, tt.max)
+		}
+		if metrics.Retries != 1 {
+			t.Errorf("told to retry after %q, counted %d retries, want 1", tt.retryAfter, metrics.Retries)
+		}
+	}
+}
+
+func TestMultiFetchGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	fmt.Fprint(zw, `{"count": 1, "next": null, "results": [{"id": 1}]}`)
+	zw.Close()
+	tests := []struct {
+		name         string
+		first        []byte // What the first response has in it, after which they are all compressed
+		wantRequests int
+	}{
+		{"compressed", compressed.Bytes(), 1},
+		{"empty", nil, 2},
+		{"blank", []byte("\n"), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				// As a proxy might, whether or not we asked for it
+				w.Header().Set("Content-Encoding", "gzip")
+				if requests == 1 {
+					w.Write(tt.first)
+				} else {
+					w.Write(compressed.Bytes())
+				}
+			}))
+			defer server.Close()
+
+			// So that it's us decompressing it, not Go
+			client := server.Client()
+			client.Transport.(*http.Transport).DisableCompression = true
+			var metrics EndpointMetrics
+			body, err := multiFetch(context.Background(), client, testFetchConf(server.URL), "sessions", &metrics)
+			if err != nil {
+				t.Fatalf("multiFetch failed: %s", err)
+			}
+			if string(body) != `[{"id":1}]` {
+				t.Errorf("multiFetch gave %s, want the one session", body)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("made %d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestGroupLinksTwice(t *testing.T) {
+	tests := []struct {
+		categories []string // Those Bob is linked to the session under, in turn
+		want       string
+	}{
+		{[]string{"Panelist", "Moderator"}, "Panelist"},
+		{[]string{"Moderator", "Panelist"}, "Moderator"},
+		{[]string{"Panelist", "Guest of Honour"}, "Guest of Honour"},
+		{[]string{"Guest of Honor", "Panelist", "Moderator"}, "Guest of Honor"},
+	}
+	for _, tt := range tests {
+		links := make([]CatLink, 0)
+		for i, category := range tt.categories {
+			links = append(links, CatLink{
+				ID:         i + 1,
+				SourceType: GB_TARGET_TYPE_SESSION,
+				TargetType: GB_TARGET_TYPE_LISTITEM,
+				SourceID:   1,
+				TargetID:   501,
+				Category:   map[string]any{"id": float64(i + 10), "name": category},
+			})
+		}
+		var gb GuideBook
+		gb.groupLinks(links)
+		targets := gb.SessionLinks[1].TargetIDs
+		if len(targets) != 1 || targets[501].Category != tt.want {
+			t.Errorf("linked as %q, Bob was grouped as %+v, want once as %s", tt.categories, targets, tt.want)
+		}
+	}
+}
+
+func TestResolveNestedLists(t *testing.T) {
+	// Alice, a Guest of Honor, links to her Bibliography, one book in which links to its Short
+	// Stories, one of which links back to the Participants in a loop
+	tests := []struct {
+		depth int
+		want  map[int][]int
+	}{
+		{0, map[int][]int{1153959: {500}, 200: {502, 501, 500}, 300: {503}, 301: {504}}},
+		{1, map[int][]int{1153959: {500, 503}, 200: {502, 501, 500, 503}, 300: {503, 504}, 301: {502, 501, 500, 504}}},
+		{2, map[int][]int{1153959: {500, 503, 504}, 200: {502, 501, 500, 503, 504}, 300: {502, 501, 500, 503, 504}, 301: {502, 501, 500, 503, 504}}},
+		{10, map[int][]int{1153959: {502, 501, 500, 503, 504}, 200: {502, 501, 500, 503, 504}, 300: {502, 501, 500, 503, 504}, 301: {502, 501, 500, 503, 504}}},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "nested-lists")
+		c.ListNestingDepth = tt.depth
+		gb, err := loadGuidebook(context.Background(), c)
+		if err != nil {
+			t.Fatalf("loadGuidebook failed: %s", err)
+		}
+		for id, want := range tt.want {
+			if got := gb.Lists[id].Items; !slices.Equal(got, want) {
+				t.Errorf("to a depth of %d list %d has %v, want %v", tt.depth, id, got, want)
+			}
+		}
+		// Only those directly on the list, whatever they link to
+		if !maps.Equal(gb.GuestsOfHonor, map[int]string{500: "Alice"}) {
+			t.Errorf("to a depth of %d the Guests of Honor are %v, want only Alice", tt.depth, gb.GuestsOfHonor)
+		}
+	}
+}
+
+func TestOneClient(t *testing.T) {
+	// The guide in testdata/guide, with each resource in two pages
+	var addresses []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addresses = append(addresses, r.RemoteAddr)
+		results := make([]json.RawMessage, 0)
+		if data, err := os.ReadFile(filepath.Join("testdata", "guide", strings.Trim(r.URL.Path, "/")+".raw.json")); err == nil {
+			if err := json.Unmarshal(data, &results); err != nil {
+				t.Fatal(err)
+			}
+		}
+		half := len(results) / 2
+		page := map[string]any{"count": len(results), "next": nil, "results": results[:half]}
+		if r.URL.Query().Get("page") == "2" {
+			page["results"] = results[half:]
+		} else {
+			page["next"] = "http://" + r.Host + r.URL.Path + "?page=2"
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	c := testConf(t, "")
+	c.LocalDataDir, c.DumpRawDir, c.Since, c.OAuth = "", "", "", nil
+	c.GuidebookBaseURL, c.GuidebookID, c.GuidebookAPIKey = server.URL, "1234", "key"
+	gb, err := loadGuidebook(context.Background(), c)
+	if err != nil {
+		t.Fatalf("loadGuidebook failed: %s", err)
+	}
+	if len(gb.Sessions) != 5 {
+		t.Errorf("fetched %d sessions, want all 5 from both pages", len(gb.Sessions))
+	}
+	// The one client keeps the one connection open from the first request to the last
+	if len(addresses) < 2 || slices.ContainsFunc(addresses, func(address string) bool { return address != addresses[0] }) {
+		t.Errorf("the requests came from %q, want them all from the one connection", addresses)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+const DESCRIPTION_HTML = "html"
+const DESCRIPTION_TEXT = "text"
+const DESCRIPTION_MARKDOWN = "markdown"
+
+var htmlTag = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9]*)([^>]*)>|<!--[\s\S]*?-->`)
+var htmlHref = regexp.MustCompile(`(?i)href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+var collapseSpaces = regexp.MustCompile(`[ \t\r\n]+`)
+var trailingSpaces = regexp.MustCompile(`[ \t]+\n`)
+var extraNewlines = regexp.MustCompile(`\n{3,}`)
+
+// htmlLink is an <a> we are inside, and where its text started in the output
+type htmlLink struct {
+	href  string
+	start int
+}
+
+// htmlList tracks the kind of list we are in, and how far through an ordered one we are
+type htmlList struct {
+	ordered bool
+	count   int
+}
+
+// ConvertDescription turns a Guidebook description_html into the requested format:
+// "html" leaves it untouched, "text" strips the markup and "markdown" converts links,
+// emphasis, lists and line breaks into their Markdown equivalents.
+func ConvertDescription(description, format string) string {
+	if format != DESCRIPTION_TEXT && format != DESCRIPTION_MARKDOWN {
+		return description
+	}
+	markdown := format == DESCRIPTION_MARKDOWN
+
+	var out strings.Builder
+	lists := make([]htmlList, 0)
+	links := make([]htmlLink, 0)
+
+	newline := func(count int) {
+		current := out.String()
+		existing := len(current) - len(strings.TrimRight(current, "\n"))
+		if len(current) == 0 {
+			return
+		}
+		for ; existing < count; existing++ {
+			out.WriteString("\n")
+		}
+	}
+
+	// Markdown emphasis can't start or end with a space, so the markers for <b> and <i> wait
+	// for the text they emphasise, and any space at the end of it goes outside the closing one.
+	pending := ""
+	emphasis := func(marker string, closing bool) {
+		if !closing {
+			pending += marker
+			return
+		}
+		if strings.HasSuffix(pending, marker) {
+			pending = strings.TrimSuffix(pending, marker) // Nothing to emphasise
+			return
+		}
+		current := out.String()
+		trimmed := strings.TrimRight(current, " \n")
+		out.Reset()
+		out.WriteString(trimmed + marker + current[len(trimmed):])
+	}
+
+	text := func(s string) {
+		s = collapseSpaces.ReplaceAllString(html.UnescapeString(s), " ")
+		if current := out.String(); current == "" || strings.HasSuffix(current, "\n") || strings.HasSuffix(current, " ") {
+			s = strings.TrimLeft(s, " ") // Spaces either side of a tag are only one space
+		}
+		if pending != "" && strings.TrimSpace(s) != "" {
+			words := strings.TrimLeft(s, " ")
+			s = s[:len(s)-len(words)] + pending + words
+			pending = ""
+		}
+		out.WriteString(s)
+	}
+
+	last := 0
+	for _, m := range htmlTag.FindAllStringSubmatchIndex(description, -1) {
+		text(description[last:m[0]])
+		last = m[1]
+		if m[4] < 0 {
+			continue // A comment
+		}
+		closing := m[3] > m[2]
+		tag := strings.ToLower(description[m[4]:m[5]])
+		attributes := description[m[6]:m[7]]
+
+		switch tag {
+		case "br":
+			newline(1)
+		case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote":
+			newline(2)
+		case "b", "strong":
+			if markdown {
+				emphasis("**", closing)
+			}
+		case "i", "em":
+			if markdown {
+				emphasis("_", closing)
+			}
+		case "ul", "ol":
+			if closing {
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				newline(2)
+			} else {
+				lists = append(lists, htmlList{ordered: tag == "ol"})
+				newline(1)
+			}
+		case "li":
+			if closing {
+				newline(1)
+				continue
+			}
+			newline(1)
+			bullet := "- "
+			if len(lists) > 0 {
+				out.WriteString(strings.Repeat("  ", len(lists)-1))
+				list := &lists[len(lists)-1]
+				if list.ordered {
+					list.count++
+					bullet = fmt.Sprintf("%d. ", list.count)
+				}
+			}
+			out.WriteString(bullet)
+		case "a":
+			if !closing {
+				href := ""
+				if h := htmlHref.FindStringSubmatch(attributes); h != nil {
+					href = html.UnescapeString(h[1] + h[2] + h[3])
+				}
+				if markdown && href != "" {
+					out.WriteString(pending + "[")
+					pending = ""
+				}
+				links = append(links, htmlLink{href: href, start: out.Len()})
+				continue
+			}
+			if len(links) == 0 {
+				continue
+			}
+			link := links[len(links)-1]
+			links = links[:len(links)-1]
+			if link.href == "" {
+				continue
+			}
+			if markdown {
+				out.WriteString("](" + link.href + ")")
+			} else if strings.TrimSpace(out.String()[link.start:]) != link.href {
+				out.WriteString(" (" + link.href + ")")
+			}
+		}
+	}
+	text(description[last:])
+
+	result := trailingSpaces.ReplaceAllString(out.String(), "\n")
+	result = extraNewlines.ReplaceAllString(result, "\n\n")
+	return strings.TrimSpace(result)
+}
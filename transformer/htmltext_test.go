@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestConvertDescription(t *testing.T) {
+	tests := []struct {
+		html     string
+		text     string
+		markdown string
+	}{
+		{"", "", ""},
+		{"Just words", "Just words", "Just words"},
+		{"<p>Welcome <b>all</b> &amp; everyone</p>", "Welcome all & everyone", "Welcome **all** & everyone"},
+		{"<p>One</p><p>Two<br>Three</p>", "One\n\nTwo\nThree", "One\n\nTwo\nThree"},
+		{"A <b> bold </b> move", "A bold move", "A **bold** move"},
+		{"A <strong>bold </strong>move", "A bold move", "A **bold** move"},
+		{"An <i> aside</i>.", "An aside.", "An _aside_."},
+		{"<em>Nothing</em><b> </b>here", "Nothing here", "_Nothing_ here"},
+		{"<b><i> both </i></b>", "both", "**_both_**"},
+		{"<b>Line<br></b>Next", "Line\nNext", "**Line**\nNext"},
+		{`See <a href="https://example.org">the site</a>`, "See the site (https://example.org)", "See [the site](https://example.org)"},
+		{`<b><a href="https://example.org">Bold link</a></b>`, "Bold link (https://example.org)", "**[Bold link](https://example.org)**"},
+		{`<a href="https://example.org">https://example.org</a>`, "https://example.org", "[https://example.org](https://example.org)"},
+		{"<ul><li>One</li><li>Two<ol><li>A</li><li>B</li></ol></li></ul>", "- One\n- Two\n  1. A\n  2. B", "- One\n- Two\n  1. A\n  2. B"},
+		{"Before<!-- hidden -->After", "BeforeAfter", "BeforeAfter"},
+	}
+	for _, tt := range tests {
+		if got := ConvertDescription(tt.html, DESCRIPTION_HTML); got != tt.html {
+			t.Errorf("ConvertDescription(%q, html) = %q, want it unchanged", tt.html, got)
+		}
+		if got := ConvertDescription(tt.html, DESCRIPTION_TEXT); got != tt.text {
+			t.Errorf("ConvertDescription(%q, text) = %q, want %q", tt.html, got, tt.text)
+		}
+		if got := ConvertDescription(tt.html, DESCRIPTION_MARKDOWN); got != tt.markdown {
+			t.Errorf("ConvertDescription(%q, markdown) = %q, want %q", tt.html, got, tt.markdown)
+		}
+	}
+}
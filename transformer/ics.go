@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const ICS_TIME_FORMAT = "20060102T150405Z"
+
+// icsEscape escapes a TEXT value as RFC 5545 requires
+var icsEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// writeICSLine writes one content line, folded so that no line is longer than 75 octets
+// and without splitting a UTF-8 character across the fold.
+func writeICSLine(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // Allowing for the space at the start of each continuation
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+// WatsonToICS writes the sessions as an iCalendar file, with one event for each, so that
+// attendees can subscribe to the schedule in their calendar.  stamp is when it was generated.
+func WatsonToICS(w io.Writer, sessions []WatsonSession, stamp time.Time) error {
+	out := bufio.NewWriter(w)
+	writeICSLine(out, "BEGIN:VCALENDAR")
+	writeICSLine(out, "VERSION:2.0")
+	writeICSLine(out, "PRODID:-//mcmillan.nz//gb-xformer//EN")
+	writeICSLine(out, "CALSCALE:GREGORIAN")
+	for _, ws := range sessions {
+		uid := ws.EntryID
+		if uid == "" {
+			uid = fmt.Sprint(ws.ID)
+		}
+		writeICSLine(out, "BEGIN:VEVENT")
+		writeICSLine(out, "UID:session-"+uid+"@gb-xformer")
+		writeICSLine(out, "DTSTAMP:"+stamp.UTC().Format(ICS_TIME_FORMAT))
+		writeICSLine(out, "DTSTART:"+ws.start.UTC().Format(ICS_TIME_FORMAT))
+		writeICSLine(out, "DTEND:"+ws.start.Add(time.Duration(ws.DurationMinutes)*time.Minute).UTC().Format(ICS_TIME_FORMAT))
+		writeICSLine(out, "SUMMARY:"+icsEscape.Replace(ws.Name))
+		// From the HTML, since the description in the schedule may be HTML or Markdown
+		if description := ConvertDescription(ws.descriptionHTML, DESCRIPTION_TEXT); description != "" {
+			writeICSLine(out, "DESCRIPTION:"+icsEscape.Replace(description))
+		}
+		if len(ws.Locations) > 0 {
+			writeICSLine(out, "LOCATION:"+icsEscape.Replace(strings.Join(ws.Locations, ", ")))
+		}
+		if ws.Links.Session != "" {
+			writeICSLine(out, "URL:"+ws.Links.Session)
+		}
+		writeICSLine(out, "END:VEVENT")
+	}
+	writeICSLine(out, "END:VCALENDAR")
+	return out.Flush()
+}
+
+func writeICSFile(path string, sessions []WatsonSession, stamp time.Time) error {
+	return writeFileAtomic(path, func(w io.Writer) error { return WatsonToICS(w, sessions, stamp) })
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWriteICSLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Opening Ceremony"},
+		{"exactly 75", "SUMMARY:" + strings.Repeat("x", 67)},
+		{"long", "DESCRIPTION:" + strings.Repeat("abcdefghij", 20)},
+		{"multi-byte", "SUMMARY:" + strings.Repeat("日本語", 30)},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		writeICSLine(w, tt.line)
+		w.Flush()
+
+		if !strings.HasSuffix(out.String(), "\r\n") {
+			t.Errorf("%s: %q doesn't end with CRLF", tt.name, out.String())
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+		var unfolded strings.Builder
+		for i, line := range lines {
+			if len(line) > 75 {
+				t.Errorf("%s: line %d is %d octets long", tt.name, i, len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("%s: line %d splits a character: %q", tt.name, i, line)
+			}
+			if i > 0 {
+				if !strings.HasPrefix(line, " ") {
+					t.Errorf("%s: continuation line %d doesn't start with a space", tt.name, i)
+				}
+				line = line[1:]
+			}
+			unfolded.WriteString(line)
+		}
+		if unfolded.String() != tt.line {
+			t.Errorf("%s: unfolds to %q, want %q", tt.name, unfolded.String(), tt.line)
+		}
+		if len(tt.line) <= 75 && len(lines) != 1 {
+			t.Errorf("%s: folded into %d lines, but fits in one", tt.name, len(lines))
+		}
+	}
+}
+
+func TestICSEscape(t *testing.T) {
+	if got, want := icsEscape.Replace("Tea, cake; and a\\b\nnext line"), `Tea\, cake\; and a\\b\nnext line`; got != want {
+		t.Errorf("icsEscape = %q, want %q", got, want)
+	}
+}
+
+func TestWatsonToICSDescription(t *testing.T) {
+	for _, format := range []string{DESCRIPTION_HTML, DESCRIPTION_MARKDOWN, DESCRIPTION_TEXT} {
+		c := testConf(t, "")
+		c.DescriptionFormat = format
+		_, sessions := testSessions(t, c)
+		var out bytes.Buffer
+		if err := WatsonToICS(&out, []WatsonSession{sessionByID(t, sessions, 1)}, c.Now()); err != nil {
+			t.Fatalf("WatsonToICS failed: %s", err)
+		}
+		// The description is <p>Welcome <b>all</b> &amp; everyone</p>, which is plain text whatever the format
+		if want := "\r\nDESCRIPTION:Welcome all & everyone\r\n"; !strings.Contains(out.String(), want) {
+			t.Errorf("with DESCRIPTION_FORMAT=%s the calendar is\n%s\nwant it to contain %q", format, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// mergeSnapshot merges the records fetched with --since into the snapshot of everything that
+// -dump-raw wrote at snapshotPath last time, replacing records with the same "id" and adding
+// new ones at the end.  Records deleted in Guidebook since then can't be seen in an incremental
+// fetch, so they stay in the snapshot until the next full fetch.
+func mergeSnapshot(snapshotPath string, fetched []byte) ([]byte, error) {
+	snapshotBytes, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("no snapshot to merge into, so a full fetch is needed first: %w", err)
+	}
+	snapshot := make([]map[string]any, 0)
+	if err := json.Unmarshal(snapshotBytes, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %q: %w", snapshotPath, err)
+	}
+	updates := make([]map[string]any, 0)
+	if err := json.Unmarshal(fetched, &updates); err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(snapshot))
+	for i, record := range snapshot {
+		index[fmt.Sprint(record["id"])] = i
+	}
+	for _, record := range updates {
+		id := fmt.Sprint(record["id"])
+		if i, exists := index[id]; exists {
+			snapshot[i] = record
+		} else {
+			index[id] = len(snapshot)
+			snapshot = append(snapshot, record)
+		}
+	}
+	return json.Marshal(snapshot)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// EndpointMetrics is what it took to fetch everything from one API endpoint
+type EndpointMetrics struct {
+	Requests int           `json:"requests"`
+	Retries  int           `json:"retries"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+}
+
+// FetchMetrics are the EndpointMetrics for each endpoint we fetched, by name, e.g. "sessions"
+type FetchMetrics map[string]*EndpointMetrics
+
+// For returns the metrics for the named endpoint, starting them if need be
+func (fm FetchMetrics) For(endpoint string) *EndpointMetrics {
+	metrics, exists := fm[endpoint]
+	if !exists {
+		metrics = &EndpointMetrics{}
+		fm[endpoint] = metrics
+	}
+	return metrics
+}
+
+// Log writes a table of the metrics for each endpoint, with their totals, to the log
+func (fm FetchMetrics) Log() {
+	if len(fm) == 0 {
+		return
+	}
+	endpoints := make([]string, 0, len(fm))
+	for endpoint := range fm {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Endpoint\tRequests\tRetries\tBytes\tDuration\t")
+	var total EndpointMetrics
+	for _, endpoint := range endpoints {
+		m := fm[endpoint]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t\n", endpoint, m.Requests, m.Retries, m.Bytes, m.Duration.Round(time.Millisecond))
+		total.Requests += m.Requests
+		total.Retries += m.Retries
+		total.Bytes += m.Bytes
+		total.Duration += m.Duration
+	}
+	fmt.Fprintf(tw, "Total\t%d\t%d\t%d\t%s\t\n", total.Requests, total.Retries, total.Bytes, total.Duration.Round(time.Millisecond))
+	tw.Flush()
+	log.Printf("Guidebook requests by endpoint:\n%s", table.String())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// NowNextSession is the little we need to show a session on a lobby display
+type NowNextSession struct {
+	ID              int    `json:"id"`
+	Name            string `json:"title"`
+	StartTime       string `json:"dateTime"`
+	DurationMinutes int    `json:"mins"`
+}
+
+// NowNext is what is on in a location right now, and what is on next.  Either can be
+// missing, and both are missing when the location is done for the day.
+type NowNext struct {
+	LocationID int             `json:"id"`
+	Location   string          `json:"name"`
+	Now        *NowNextSession `json:"now,omitempty"`
+	Next       *NowNextSession `json:"next,omitempty"`
+}
+
+func nowNextSession(ws WatsonSession) *NowNextSession {
+	return &NowNextSession{
+		ID:              ws.ID,
+		Name:            ws.Name,
+		StartTime:       ws.StartTime,
+		DurationMinutes: ws.DurationMinutes,
+	}
+}
+
+// sameDay reports whether a session starting at start is on the same calendar day as now, in
+// the event's time zone loc.  Without one, it is the day in the offset the session was given
+// with, which is the event's local time rather than wherever we happen to be running.
+func sameDay(start, now time.Time, loc *time.Location) bool {
+	if loc == nil {
+		loc = start.Location()
+	}
+	sy, sm, sd := start.In(loc).Date()
+	ny, nm, nd := now.In(loc).Date()
+	return sy == ny && sm == nm && sd == nd
+}
+
+// BuildNowNext finds, for each location, the session running at 'now' and the next one
+// starting later on the same day in the event's time zone loc.
+func BuildNowNext(sessions []WatsonSession, now time.Time, loc *time.Location) []NowNext {
+	byLocation, names := SessionsByLocation(sessions)
+
+	result := make([]NowNext, 0, len(byLocation))
+	for id, locationSessions := range byLocation {
+		nn := NowNext{LocationID: id, Location: names[id]}
+		var nowStart, nextStart time.Time
+		for _, ws := range locationSessions {
+			if !ws.start.After(now) && ws.finish.After(now) {
+				// When things overlap, the one which started most recently is what's on
+				if nn.Now == nil || ws.start.After(nowStart) {
+					nn.Now = nowNextSession(ws)
+					nowStart = ws.start
+				}
+			} else if ws.start.After(now) && sameDay(ws.start, now, loc) {
+				if nn.Next == nil || ws.start.Before(nextStart) {
+					nn.Next = nowNextSession(ws)
+					nextStart = ws.start
+				}
+			}
+		}
+		result = append(result, nn)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Location != result[j].Location {
+			return result[i].Location < result[j].Location
+		}
+		return result[i].LocationID < result[j].LocationID
+	})
+	return result
+}
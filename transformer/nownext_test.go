@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSameDay(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skipf("no time zone data: %s", err)
+	}
+	// 23:30 on the 14th in Auckland, where the event is, but 11:30 on the 14th in UTC
+	start := time.Date(2025, 8, 14, 23, 30, 0, 0, auckland)
+	tests := []struct {
+		name string
+		now  time.Time
+		loc  *time.Location
+		want bool
+	}{
+		{"same day in the event's zone", time.Date(2025, 8, 14, 9, 0, 0, 0, auckland), auckland, true},
+		{"running somewhere else", time.Date(2025, 8, 13, 22, 0, 0, 0, time.UTC), auckland, true}, // 10:00 on the 14th in Auckland
+		{"next day in the event's zone", time.Date(2025, 8, 14, 12, 30, 0, 0, time.UTC), auckland, false},
+		{"no zone, so the session's offset", time.Date(2025, 8, 13, 22, 0, 0, 0, time.UTC), nil, true},
+		{"no zone, the day before", time.Date(2025, 8, 13, 11, 0, 0, 0, time.UTC), nil, false},
+	}
+	for _, tt := range tests {
+		if got := sameDay(start, tt.now, tt.loc); got != tt.want {
+			t.Errorf("%s: sameDay = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
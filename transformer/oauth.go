@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is how long before a token's stated expiry we treat it as expired
+const tokenExpiryMargin = 30 * time.Second
+
+// OAuthTokenSource fetches a bearer token using the OAuth2 client-credentials flow,
+// caching it until shortly before it expires.
+type OAuthTokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Token returns the cached token, fetching a new one from the token endpoint if we don't have one or it has expired
+func (ts *OAuthTokenSource) Token(client *http.Client) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && (ts.expires.IsZero() || time.Now().Before(ts.expires)) {
+		return ts.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	req, err := http.NewRequest("POST", ts.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create OAuth token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(ts.ClientID), url.QueryEscape(ts.ClientSecret))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request OAuth token: %w", err)
+	}
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OAuth token request failed with status %s: %s", resp.Status, string(bodyBytes))
+	}
+
+	var tokenResponse oauthTokenResponse
+	if err := json.Unmarshal(bodyBytes, &tokenResponse); err != nil {
+		return "", fmt.Errorf("failed to decode OAuth token response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", fmt.Errorf("OAuth token response did not include an access_token")
+	}
+
+	ts.token = tokenResponse.AccessToken
+	ts.expires = time.Time{}
+	if tokenResponse.ExpiresIn > 0 {
+		ts.expires = time.Now().Add(time.Duration(tokenResponse.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+	return ts.token, nil
+}
+
+// Invalidate forgets the cached token, so the next call to Token fetches a new one
+func (ts *OAuthTokenSource) Invalidate() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.token = ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOAuthTokens(t *testing.T) {
+	tests := []struct {
+		name        string
+		expiresIn   int
+		revokeFirst bool // Whether the API refuses the first token before it expires
+		wantTokens  int
+		wantAuths   []string
+	}{
+		{"reused until expiry", 3600, false, 1, []string{"Bearer token-1", "Bearer token-1"}},
+		{"refreshed once expired", 30, false, 2, []string{"Bearer token-1", "Bearer token-2"}}, // Within the margin, so expired straight away
+		{"refreshed when refused", 3600, true, 2, []string{"Bearer token-1", "Bearer token-2", "Bearer token-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := 0
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tokens++
+				id, secret, ok := r.BasicAuth()
+				if !ok || id != "client" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+					t.Errorf("token request %d was for %q with %q and grant %q", tokens, id, secret, r.FormValue("grant_type"))
+				}
+				fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, tokens, tt.expiresIn)
+			}))
+			defer tokenServer.Close()
+
+			auths := make([]string, 0)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auths = append(auths, r.Header.Get("Authorization"))
+				if tt.revokeFirst && r.Header.Get("Authorization") == "Bearer token-1" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprint(w, `{"count": 0, "next": null, "results": []}`)
+			}))
+			defer server.Close()
+
+			c := testFetchConf(server.URL)
+			c.OAuth = &OAuthTokenSource{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret"}
+			for _, fetchWhat := range []string{"sessions", "locations"} {
+				var metrics EndpointMetrics
+				if _, err := multiFetch(context.Background(), server.Client(), c, fetchWhat, &metrics); err != nil {
+					t.Fatalf("multiFetch for %s failed: %s", fetchWhat, err)
+				}
+			}
+			if tokens != tt.wantTokens {
+				t.Errorf("fetched %d tokens, want %d", tokens, tt.wantTokens)
+			}
+			if fmt.Sprint(auths) != fmt.Sprint(tt.wantAuths) {
+				t.Errorf("requests were authorized with %q, want %q", auths, tt.wantAuths)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+const ENVIRONMENT_IN_PERSON = "in_person"
+const ENVIRONMENT_VIRTUAL = "virtual"
+const ENVIRONMENT_HYBRID = "hybrid"
+
+// SessionOverride is a correction to what we derive from Guidebook for one session
+type SessionOverride struct {
+	Environment string `json:"environment,omitempty"`
+}
+
+// Overrides lets staff correct the output without changing Guidebook mid-event.  It is
+// read from the JSON file at OVERRIDES_PATH, e.g.
+//
+//	{"sessions": {"31506731": {"environment": "virtual"}}}
+type Overrides struct {
+	Sessions map[int]SessionOverride `json:"sessions"`
+}
+
+func loadOverrides(path string) (Overrides, error) {
+	overrides := Overrides{Sessions: make(map[int]SessionOverride)}
+	if path == "" {
+		return overrides, nil
+	}
+	overrideBytes, err := os.ReadFile(path)
+	if err != nil {
+		return overrides, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	if err := json.Unmarshal(overrideBytes, &overrides); err != nil {
+		return overrides, fmt.Errorf("failed to decode overrides from %q: %w", path, err)
+	}
+	for id, override := range overrides.Sessions {
+		switch override.Environment {
+		case "", ENVIRONMENT_IN_PERSON, ENVIRONMENT_VIRTUAL, ENVIRONMENT_HYBRID:
+		default:
+			return overrides, fmt.Errorf("the environment override for session %d must be %q, %q or %q", id, ENVIRONMENT_IN_PERSON, ENVIRONMENT_VIRTUAL, ENVIRONMENT_HYBRID)
+		}
+	}
+	return overrides, nil
+}
+
+// OverrideEnvironment forces the session to be in person, virtual or both, replacing
+// the environment tags that BuildSessionTags worked out from its rooms and tracks.
+func (ws *WatsonSession) OverrideEnvironment(environment string) {
+	if environment == "" {
+		return
+	}
+	log.Printf("Overriding the environment of session (%d, %s) to %s", ws.ID, ws.Name, environment)
+	ws.in_person = environment == ENVIRONMENT_IN_PERSON || environment == ENVIRONMENT_HYBRID
+	ws.virtual = environment == ENVIRONMENT_VIRTUAL || environment == ENVIRONMENT_HYBRID
+
+	tags := make([]Tag, 0, len(ws.Tags))
+	for _, tag := range ws.Tags {
+		if tag.Category != "Environment" {
+			tags = append(tags, tag)
+		}
+	}
+	if ws.in_person {
+		tags = append(tags, makeTag("In Person Session", "session_in_person", "Environment"))
+	}
+	if ws.virtual {
+		tags = append(tags, makeTag("Virtual Session", "session_virtual", "Environment"))
+	}
+	ws.Tags = tags
+}
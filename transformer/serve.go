@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// scheduleCache holds the outputs from the latest successful fetch, for serving
+type scheduleCache struct {
+	mu        sync.RWMutex
+	schedule  []byte
+	streaming []byte
+	fetchedAt time.Time
+	lastError error
+}
+
+func (sc *scheduleCache) get() (schedule, streaming []byte, fetchedAt time.Time, lastError error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.schedule, sc.streaming, sc.fetchedAt, sc.lastError
+}
+
+// refresh fetches everything from Guidebook again and, if that all worked, replaces what
+// is being served.  When it fails we carry on serving what we had.
+func (sc *scheduleCache) refresh(ctx context.Context, c conf) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	schedule, streaming, err := buildServed(ctx, c)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.lastError = err
+	if err != nil {
+		return err
+	}
+	sc.schedule = schedule
+	sc.streaming = streaming
+	sc.fetchedAt = time.Now()
+	return nil
+}
+
+func buildServed(ctx context.Context, c conf) (schedule, streaming []byte, err error) {
+	guidebook, err := loadGuidebook(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	watsonSessions, err := WatsonFromGuidebook(guidebook)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkVirtualStreams(c, watsonSessions); err != nil {
+		return nil, nil, err
+	}
+	if err := checkInPersonLocations(c, guidebook, watsonSessions); err != nil {
+		return nil, nil, err
+	}
+	if c.StreamsOnly {
+		watsonSessions = FilterStreamable(watsonSessions)
+	}
+
+	schedule, err = EncodeSchedule(c, watsonSessions)
+	if err != nil {
+		return nil, nil, err
+	}
+	var csv bytes.Buffer
+	if err := StreamingCSV(&csv, c, watsonSessions); err != nil {
+		return nil, nil, err
+	}
+	return schedule, csv.Bytes(), nil
+}
+
+func (sc *scheduleCache) handler() http.Handler {
+	mux := http.NewServeMux()
+	serveCached := func(contentType string, pick func(schedule, streaming []byte) []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			schedule, streaming, fetchedAt, _ := sc.get()
+			body := pick(schedule, streaming)
+			if body == nil {
+				http.Error(w, "the schedule hasn't been fetched yet", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			http.ServeContent(w, r, "", fetchedAt, bytes.NewReader(body))
+		}
+	}
+	mux.HandleFunc("GET /schedule.json", serveCached("application/json", func(schedule, _ []byte) []byte { return schedule }))
+	mux.HandleFunc("GET /streaming.csv", serveCached("text/csv; charset=utf-8", func(_, streaming []byte) []byte { return streaming }))
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _, fetchedAt, lastError := sc.get()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		switch {
+		case fetchedAt.IsZero():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "no schedule yet")
+		case lastError != nil:
+			// We are still serving the previous schedule, so we're up, but it may be stale
+			fmt.Fprintf(w, "stale, fetched at %s, last fetch failed: %s\n", fetchedAt.Format(time.RFC3339), lastError.Error())
+		default:
+			fmt.Fprintf(w, "ok, fetched at %s\n", fetchedAt.Format(time.RFC3339))
+		}
+	})
+	return mux
+}
+
+// serve fetches the schedule every c.ServeInterval and serves the latest of it over HTTP on
+// c.ServeAddr, until something is written into c.TimeToGo.
+func serve(c conf) error {
+	if err := validateCredentials(c); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := &scheduleCache{}
+	server := &http.Server{Addr: c.ServeAddr, Handler: cache.handler()}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving the schedule on %s", c.ServeAddr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	var fetching sync.WaitGroup
+	fetching.Add(1)
+	go func() {
+		defer fetching.Done()
+		ticker := time.NewTicker(c.ServeInterval)
+		defer ticker.Stop()
+		for {
+			if err := cache.refresh(ctx, c); err != nil {
+				log.Printf("Error refreshing the schedule, still serving the previous one: %s", err.Error())
+			} else {
+				log.Println("Refreshed the schedule from Guidebook")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var err error
+	select {
+	case <-c.TimeToGo:
+	case err = <-serverErr:
+	}
+
+	log.Println("Shutting down")
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	fetching.Wait()
+	return err
+}
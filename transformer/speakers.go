@@ -0,0 +1,68 @@
+package main
+
+import (
+	"cmp"
+	"sort"
+)
+
+// Speaker is one person across the whole guide, for a directory of speakers
+type Speaker struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	Bio            string `json:"bio,omitempty"`
+	Photo          string `json:"photo,omitempty"`
+	SessionIDs     []int  `json:"sessions"`
+	IsGuestOfHonor bool   `json:"guest_of_honor,omitempty"`
+}
+
+// Speakers lists everyone linked to any of the sessions, once each, sorted by name, with
+// those of the sessions they are linked to.  Sessions which were dropped or filtered out of
+// the schedule, and anyone only on those, are left out.
+func Speakers(gb GuideBook, sessions []WatsonSession) []Speaker {
+	emitted := make(map[int]bool, len(sessions))
+	for _, ws := range sessions {
+		emitted[ws.ID] = true
+	}
+
+	byID := make(map[int]*Speaker)
+	for sessionID, links := range gb.SessionLinks {
+		if !emitted[sessionID] {
+			continue
+		}
+		for _, pl := range links.TargetIDs {
+			if pl.TargetType != GB_TARGET_TYPE_LISTITEM || !gb.isPersonItem(pl.TargetID) {
+				continue
+			}
+			item := gb.ListItems[pl.TargetID]
+			speaker, exists := byID[item.ID]
+			if !exists {
+				_, goh := gb.GuestsOfHonor[item.ID]
+				speaker = &Speaker{
+					ID:             item.ID,
+					Name:           item.Name,
+					Bio:            ConvertDescription(item.Descripion, gb.config.DescriptionFormat),
+					Photo:          gb.absoluteURL(cmp.Or(item.Image, item.Thumbnail)),
+					IsGuestOfHonor: goh,
+				}
+				byID[item.ID] = speaker
+			}
+			if isGuestOfHonorCategory(pl.Category) {
+				speaker.IsGuestOfHonor = true
+			}
+			speaker.SessionIDs = append(speaker.SessionIDs, sessionID)
+		}
+	}
+
+	speakers := make([]Speaker, 0, len(byID))
+	for _, speaker := range byID {
+		sort.Ints(speaker.SessionIDs)
+		speakers = append(speakers, *speaker)
+	}
+	sort.Slice(speakers, func(i, j int) bool {
+		if speakers[i].Name != speakers[j].Name {
+			return speakers[i].Name < speakers[j].Name
+		}
+		return speakers[i].ID < speakers[j].ID
+	})
+	return speakers
+}
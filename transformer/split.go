@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocationIndexEntry describes one of the per-location files written by WriteSessionsByLocation
+type LocationIndexEntry struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+}
+
+// SessionsByLocation groups sessions by location ID.  A session in several locations
+// appears in each of them, and sessions keep the (time) order they were given in.
+// Sessions which had no location in Guidebook are grouped under location ID 0.
+func SessionsByLocation(sessions []WatsonSession) (map[int][]WatsonSession, map[int]string) {
+	byLocation := make(map[int][]WatsonSession)
+	names := make(map[int]string)
+	for _, ws := range sessions {
+		if len(ws.locationIDs) == 0 {
+			byLocation[0] = append(byLocation[0], ws)
+			if len(ws.Locations) > 0 {
+				names[0] = ws.Locations[0]
+			}
+			continue
+		}
+		for i, id := range ws.locationIDs {
+			byLocation[id] = append(byLocation[id], ws)
+			names[id] = ws.Locations[i]
+		}
+	}
+	return byLocation, names
+}
+
+// WriteSessionsByLocation writes a location-<id>.json file for each location into dir, along
+// with an index.json mapping the location IDs to their file and name, for venue signage.
+func WriteSessionsByLocation(dir string, sessions []WatsonSession) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	byLocation, names := SessionsByLocation(sessions)
+
+	index := make([]LocationIndexEntry, 0, len(byLocation))
+	for id, locationSessions := range byLocation {
+		entry := LocationIndexEntry{
+			ID:   id,
+			Name: names[id],
+			File: fmt.Sprintf("location-%d.json", id),
+		}
+		if err := WriteJSONFile(filepath.Join(dir, entry.File), locationSessions); err != nil {
+			return err
+		}
+		index = append(index, entry)
+	}
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].ID < index[j].ID
+	})
+	return WriteJSONFile(filepath.Join(dir, "index.json"), index)
+}
+
+// SessionsBySlot groups sessions into time slots keyed by their (formatted) start time,
+// ordering the sessions within each slot by rank and then by room.
+func SessionsBySlot(sessions []WatsonSession) map[string][]WatsonSession {
+	slots := make(map[string][]WatsonSession)
+	for _, ws := range sessions {
+		slots[ws.StartTime] = append(slots[ws.StartTime], ws)
+	}
+	for _, slot := range slots {
+		sort.SliceStable(slot, func(i, j int) bool {
+			if slot[i].rank != slot[j].rank {
+				return slot[i].rank < slot[j].rank
+			}
+			return strings.Join(slot[i].Locations, ", ") < strings.Join(slot[j].Locations, ", ")
+		})
+	}
+	return slots
+}
+
+// SessionsByEnvironment splits sessions into those happening in person and those happening
+// virtually.  Hybrid sessions are in both.
+func SessionsByEnvironment(sessions []WatsonSession) (inPerson, virtual []WatsonSession) {
+	inPerson = make([]WatsonSession, 0, len(sessions))
+	virtual = make([]WatsonSession, 0, len(sessions))
+	for _, ws := range sessions {
+		if ws.in_person {
+			inPerson = append(inPerson, ws)
+		}
+		if ws.virtual {
+			virtual = append(virtual, ws)
+		}
+	}
+	return inPerson, virtual
+}
+
+// WriteSessionsByEnvironment writes schedule-inperson.json and schedule-virtual.json beside
+// the schedule at schedulePath, for in-person signage and the virtual platform respectively.
+func WriteSessionsByEnvironment(schedulePath string, sessions []WatsonSession) error {
+	inPerson, virtual := SessionsByEnvironment(sessions)
+	dir := filepath.Dir(schedulePath)
+	for _, split := range []struct {
+		file     string
+		sessions []WatsonSession
+	}{
+		{"schedule-inperson.json", inPerson},
+		{"schedule-virtual.json", virtual},
+	} {
+		path := filepath.Join(dir, split.file)
+		if err := WriteJSONFile(path, split.sessions); err != nil {
+			return err
+		}
+		log.Printf("Wrote %d sessions to %q", len(split.sessions), path)
+	}
+	return nil
+}
+
+const DAY_FORMAT = "2006-01-02"
+
+// sessionDay is the calendar day a session starts on, in loc if it is set, or otherwise in
+// whatever offset its time is shown with.  A session which runs past midnight is on its start day.
+func sessionDay(ws WatsonSession, loc *time.Location) string {
+	start := ws.start
+	if loc != nil {
+		start = start.In(loc)
+	}
+	return start.Format(DAY_FORMAT)
+}
+
+// SessionsByDay groups sessions by the day they start on, keyed like "2025-08-14"
+func SessionsByDay(sessions []WatsonSession, loc *time.Location) map[string][]WatsonSession {
+	byDay := make(map[string][]WatsonSession)
+	for _, ws := range sessions {
+		day := sessionDay(ws, loc)
+		byDay[day] = append(byDay[day], ws)
+	}
+	return byDay
+}
+
+// WriteSessionsByDay writes a schedule-YYYY-MM-DD.json file into dir for each day from firstDay
+// to lastDay, even those with no sessions, so a static site can have a page for every day.  When
+// they aren't given, the days run from the first session's to the last's.
+func WriteSessionsByDay(dir string, sessions []WatsonSession, loc *time.Location, firstDay, lastDay string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	byDay := SessionsByDay(sessions, loc)
+	if len(byDay) == 0 && (firstDay == "" || lastDay == "") {
+		return nil // No sessions, and no days configured
+	}
+	days := slices.Sorted(maps.Keys(byDay))
+	if firstDay == "" {
+		firstDay = days[0]
+	}
+	if lastDay == "" {
+		lastDay = days[len(days)-1]
+	}
+
+	first, err := time.Parse(DAY_FORMAT, firstDay)
+	if err != nil {
+		return err
+	}
+	last, err := time.Parse(DAY_FORMAT, lastDay)
+	if err != nil {
+		return err
+	}
+	written := 0
+	for date := first; !date.After(last); date = date.AddDate(0, 0, 1) {
+		day := date.Format(DAY_FORMAT)
+		daySessions := byDay[day]
+		if daySessions == nil {
+			daySessions = make([]WatsonSession, 0)
+		}
+		if err := WriteJSONFile(filepath.Join(dir, "schedule-"+day+".json"), daySessions); err != nil {
+			return err
+		}
+		written += len(daySessions)
+	}
+	if skipped := len(sessions) - written; skipped > 0 {
+		log.Printf("There were %d sessions outside the days %s to %s, which are in none of the daily files", skipped, firstDay, lastDay)
+	}
+	return nil
+}
+
+// UNTRACKED_SLUG is the file name slug for the sessions which aren't on any track
+const UNTRACKED_SLUG = "untracked"
+
+// trackSlugs gives each track the slug for its file: its name normalized the way makeTag
+// normalizes tag values.  When that leaves nothing (e.g. a name in a non-Latin script) it is
+// the track's ID instead, and a slug which is already taken, by UNTRACKED_SLUG or by an
+// earlier track, gets the track's ID on the end, so no two tracks ever share a file.
+func trackSlugs(gb GuideBook) map[int]string {
+	slugs := make(map[int]string, len(gb.Tracks))
+	used := map[string]bool{UNTRACKED_SLUG: true}
+	for _, id := range slices.Sorted(maps.Keys(gb.Tracks)) {
+		slug := makeTag("", gb.TrackName(id), "Track").Value
+		if slug == "" {
+			slug = strconv.Itoa(id)
+		}
+		if used[slug] {
+			slug = fmt.Sprintf("%s-%d", slug, id)
+		}
+		used[slug] = true
+		slugs[id] = slug
+	}
+	return slugs
+}
+
+// SessionsByTrack groups sessions by the slug of each track they are on, so a session on
+// several tracks is in each of them.  Every track in the guide is there, even with no sessions,
+// and those on no track are under UNTRACKED_SLUG.
+func SessionsByTrack(gb GuideBook, sessions []WatsonSession) map[string][]WatsonSession {
+	slugFor := trackSlugs(gb)
+	byTrack := make(map[string][]WatsonSession)
+	for _, slug := range slugFor {
+		byTrack[slug] = make([]WatsonSession, 0)
+	}
+	byTrack[UNTRACKED_SLUG] = make([]WatsonSession, 0)
+	for _, ws := range sessions {
+		slugs := make([]string, 0, len(ws.trackIDs))
+		for _, id := range ws.trackIDs {
+			slug, known := slugFor[id]
+			if !known {
+				slug = strconv.Itoa(id)
+			}
+			slugs = append(slugs, slug)
+		}
+		if len(slugs) == 0 {
+			slugs = append(slugs, UNTRACKED_SLUG)
+		}
+		slices.Sort(slugs)
+		for _, slug := range slices.Compact(slugs) {
+			byTrack[slug] = append(byTrack[slug], ws)
+		}
+	}
+	return byTrack
+}
+
+// WriteSessionsByTrack writes a track-<slug>.json file of the sessions on each track into dir
+func WriteSessionsByTrack(dir string, gb GuideBook, sessions []WatsonSession) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for slug, trackSessions := range SessionsByTrack(gb, sessions) {
+		if err := WriteJSONFile(filepath.Join(dir, "track-"+slug+".json"), trackSessions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// groupIDs gives the IDs of the sessions in each group, for comparing
+func groupIDs(groups map[string][]WatsonSession) map[string][]int {
+	ids := make(map[string][]int, len(groups))
+	for key, sessions := range groups {
+		ids[key] = make([]int, 0, len(sessions))
+		for _, ws := range sessions {
+			ids[key] = append(ids[key], ws.ID)
+		}
+	}
+	return ids
+}
+
+func equalGroups(a, b map[string][]int) bool {
+	return maps.EqualFunc(a, b, func(x, y []int) bool { return slices.Equal(x, y) })
+}
+
+func TestSessionsByDay(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skipf("no time zone data: %s", err)
+	}
+	sessions := []WatsonSession{
+		{ID: 1, start: time.Date(2025, 8, 14, 9, 0, 0, 0, auckland)},
+		{ID: 2, start: time.Date(2025, 8, 14, 23, 30, 0, 0, auckland)},
+		{ID: 3, start: time.Date(2025, 8, 14, 12, 30, 0, 0, time.UTC)}, // 00:30 on the 15th in Auckland
+		{ID: 4, start: time.Date(2025, 8, 15, 10, 0, 0, 0, auckland)},
+	}
+	tests := []struct {
+		name string
+		loc  *time.Location
+		want map[string][]int
+	}{
+		{"event time zone", auckland, map[string][]int{
+			"2025-08-14": {1, 2},
+			"2025-08-15": {3, 4},
+		}},
+		{"offsets as given", nil, map[string][]int{
+			"2025-08-14": {1, 2, 3},
+			"2025-08-15": {4},
+		}},
+	}
+	for _, tt := range tests {
+		if got := groupIDs(SessionsByDay(sessions, tt.loc)); !equalGroups(got, tt.want) {
+			t.Errorf("%s: SessionsByDay = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSessionsByTrack(t *testing.T) {
+	gb := GuideBook{Tracks: map[int]ScheduleTrack{
+		100: {ID: 100, Name: "Main Stage"},
+		101: {ID: 101, Name: "日本語"},
+		102: {ID: 102, Name: "中文"},
+		103: {ID: 103, Name: "Untracked"},
+		104: {ID: 104, Name: "Main-Stage"},
+		105: {ID: 105, Name: "Empty"},
+	}}
+	sessions := []WatsonSession{
+		{ID: 1, trackIDs: []int{100}},
+		{ID: 2, trackIDs: []int{101, 102}},
+		{ID: 3},
+		{ID: 4, trackIDs: []int{103, 104}},
+		{ID: 5, trackIDs: []int{100, 104}},
+	}
+	want := map[string][]int{
+		"main_stage":    {1, 5},
+		"101":           {2},
+		"102":           {2},
+		"untracked-103": {4},
+		"mainstage":     {4, 5},
+		"empty":         {},
+		UNTRACKED_SLUG:  {3},
+	}
+	if got := groupIDs(SessionsByTrack(gb, sessions)); !equalGroups(got, want) {
+		t.Errorf("SessionsByTrack = %v, want %v", got, want)
+	}
+}
+
+func TestTrackSlugsCollide(t *testing.T) {
+	gb := GuideBook{Tracks: map[int]ScheduleTrack{
+		7: {ID: 7, Name: "Art Show"},
+		3: {ID: 3, Name: "Art_Show"},
+		5: {ID: 5, Name: "art show!"},
+	}}
+	want := map[int]string{3: "art_show", 5: "art_show-5", 7: "art_show-7"}
+	if got := trackSlugs(gb); !maps.Equal(got, want) {
+		t.Errorf("trackSlugs = %v, want %v", got, want)
+	}
+}
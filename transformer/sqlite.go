@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "modernc.org/sqlite" // Pure Go, so we can still build with CGO_ENABLED=0
+)
+
+const sqliteSchema = `
+CREATE TABLE sessions (
+	id INTEGER PRIMARY KEY,
+	title TEXT NOT NULL,
+	description TEXT,
+	start_time TEXT NOT NULL,
+	duration_minutes INTEGER NOT NULL,
+	format TEXT,
+	in_person INTEGER NOT NULL,
+	virtual INTEGER NOT NULL,
+	session_link TEXT,
+	replay_link TEXT,
+	chat_link TEXT
+);
+CREATE TABLE locations (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE session_locations (
+	session_id INTEGER NOT NULL REFERENCES sessions(id),
+	location_id INTEGER NOT NULL REFERENCES locations(id),
+	PRIMARY KEY (session_id, location_id)
+);
+CREATE TABLE people (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE session_people (
+	session_id INTEGER NOT NULL REFERENCES sessions(id),
+	person_id INTEGER NOT NULL REFERENCES people(id),
+	role TEXT,
+	PRIMARY KEY (session_id, person_id)
+);
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	value TEXT NOT NULL,
+	label TEXT NOT NULL,
+	category TEXT NOT NULL,
+	UNIQUE (value, category)
+);
+CREATE TABLE session_tags (
+	session_id INTEGER NOT NULL REFERENCES sessions(id),
+	tag_id INTEGER NOT NULL REFERENCES tags(id),
+	PRIMARY KEY (session_id, tag_id)
+);
+`
+
+// WriteSQLite writes the sessions into a new SQLite database at path, with the
+// people, locations and tags normalised into their own tables for ad-hoc querying.
+// It is built beside path and then renamed over any database already there.
+func WriteSQLite(path string, sessions []WatsonSession) error {
+	f, err := createSibling(path)
+	if err != nil {
+		return fmt.Errorf("failed to create a new database beside %q: %w", path, err)
+	}
+	f.Close()
+	if err := writeSQLite(f.Name(), sessions); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func writeSQLite(path string, sessions []WatsonSession) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open database %q: %w", path, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := insertSessions(tx, sessions); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertSessions(tx *sql.Tx, sessions []WatsonSession) error {
+	tagIDs := make(map[Tag]int64)
+	inserted := make(map[int]bool)
+	for _, ws := range sessions {
+		// With MULTI_ROOM=explode a session comes once per location, but it is still one session
+		if inserted[ws.ID] {
+			if err := insertSessionLocations(tx, ws); err != nil {
+				return err
+			}
+			continue
+		}
+		inserted[ws.ID] = true
+
+		if _, err := tx.Exec(`INSERT INTO sessions (id, title, description, start_time, duration_minutes, format, in_person, virtual, session_link, replay_link, chat_link)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			ws.ID, ws.Name, ws.Description, ws.StartTime, ws.DurationMinutes, ws.Format, ws.in_person, ws.virtual,
+			ws.Links.Session, ws.Links.Replay, ws.Links.Chat); err != nil {
+			return fmt.Errorf("failed to insert session %d: %w", ws.ID, err)
+		}
+
+		if err := insertSessionLocations(tx, ws); err != nil {
+			return err
+		}
+
+		for _, p := range ws.People {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO people (id, name) VALUES (?, ?)`, p.ID, p.Name); err != nil {
+				return fmt.Errorf("failed to insert person %d: %w", p.ID, err)
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO session_people (session_id, person_id, role) VALUES (?, ?, ?)`, ws.ID, p.ID, p.Role); err != nil {
+				return fmt.Errorf("failed to insert person %d for session %d: %w", p.ID, ws.ID, err)
+			}
+		}
+
+		for _, tag := range ws.Tags {
+			key := Tag{Value: tag.Value, Category: tag.Category}
+			tagID, exists := tagIDs[key]
+			if !exists {
+				result, err := tx.Exec(`INSERT INTO tags (value, label, category) VALUES (?, ?, ?)`, tag.Value, tag.Label, tag.Category)
+				if err != nil {
+					return fmt.Errorf("failed to insert tag %q: %w", tag.Value, err)
+				}
+				if tagID, err = result.LastInsertId(); err != nil {
+					return err
+				}
+				tagIDs[key] = tagID
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO session_tags (session_id, tag_id) VALUES (?, ?)`, ws.ID, tagID); err != nil {
+				return fmt.Errorf("failed to insert tag %q for session %d: %w", tag.Value, ws.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func insertSessionLocations(tx *sql.Tx, ws WatsonSession) error {
+	for i, id := range ws.locationIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO locations (id, name) VALUES (?, ?)`, id, ws.Locations[i]); err != nil {
+			return fmt.Errorf("failed to insert location %d: %w", id, err)
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO session_locations (session_id, location_id) VALUES (?, ?)`, ws.ID, id); err != nil {
+			return fmt.Errorf("failed to insert location %d for session %d: %w", id, ws.ID, err)
+		}
+	}
+	return nil
+}
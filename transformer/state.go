@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// State is what we remember from one run to the next, kept as JSON in STATE_PATH
+type State struct {
+	GuideID      string    `json:"guide_id"`
+	GeneratedAt  time.Time `json:"generated_at"`
+	SessionCount int       `json:"session_count"`
+
+	// The rows of each of the link CSVs, by "stream", "chat" or "replay"
+	Links map[string][]LinkRow `json:"links,omitempty"`
+}
+
+// loadState reads the state from the previous run of guideID.  If there was no previous run
+// (or no STATE_PATH configured) it returns false and an empty State.  State left by a run of
+// some other guide is an error, rather than something to compare this guide against.
+func loadState(path, guideID string) (State, bool, error) {
+	var state State
+	if path == "" {
+		return state, false, nil
+	}
+	stateBytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, fmt.Errorf("failed to read state from %q: %w", path, err)
+	}
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return state, false, fmt.Errorf("failed to decode state from %q: %w", path, err)
+	}
+	if state.GuideID != guideID {
+		return state, false, fmt.Errorf("the state in %q is for guide %q, not %q", path, state.GuideID, guideID)
+	}
+	return state, true, nil
+}
+
+// saveState records the state of this run for the next one
+func saveState(path string, state State) error {
+	if path == "" {
+		return nil
+	}
+	return WriteJSONFile(path, state)
+}
+
+// checkSessionCountDrop guards against publishing a schedule which has suddenly lost a
+// lot of sessions.  A maxDrop below 1 is a fraction of the previous count, otherwise it
+// is an absolute number of sessions; zero disables the check.
+func checkSessionCountDrop(previous State, hadPrevious bool, count int, maxDrop float64) error {
+	if !hadPrevious || maxDrop <= 0 || count >= previous.SessionCount {
+		return nil
+	}
+	drop := float64(previous.SessionCount - count)
+	limit := maxDrop
+	if maxDrop < 1 {
+		limit = maxDrop * float64(previous.SessionCount)
+	}
+	if drop > limit {
+		return fmt.Errorf("session count dropped from %d to %d, which is more than the allowed drop of %g: refusing to write the schedule", previous.SessionCount, count, maxDrop)
+	}
+	return nil
+}
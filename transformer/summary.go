@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+)
+
+const SORT_BY_COUNT = "count"
+const SORT_BY_NAME = "name"
+
+// TrackSummary is a schedule track along with how many sessions are on it
+type TrackSummary struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	Description string `json:"description,omitempty"`
+	Count       int    `json:"count"`
+}
+
+// TrackSummaries counts the sessions on each track.  Tracks with no sessions are included
+// with a zero count.  They are sorted by the most sessions first, or by name.  A session
+// exploded into several rooms by MULTI_ROOM=explode still only counts once.
+func TrackSummaries(gb GuideBook, sessions []WatsonSession, sortBy string) []TrackSummary {
+	sessionIDs := make(map[int]map[int]bool)
+	for _, ws := range sessions {
+		for _, id := range ws.trackIDs {
+			if sessionIDs[id] == nil {
+				sessionIDs[id] = make(map[int]bool)
+			}
+			sessionIDs[id][ws.ID] = true
+		}
+	}
+
+	summaries := make([]TrackSummary, 0, len(gb.Tracks))
+	for id, track := range gb.Tracks {
+		summaries = append(summaries, TrackSummary{
+			ID:          id,
+			Name:        track.Name,
+			Color:       track.Color,
+			Description: track.Description,
+			Count:       len(sessionIDs[id]),
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if sortBy != SORT_BY_NAME && summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		if summaries[i].Name != summaries[j].Name {
+			return summaries[i].Name < summaries[j].Name
+		}
+		return summaries[i].ID < summaries[j].ID
+	})
+	return summaries
+}
+
+// TagFacet is one tag value, and how many sessions carry it
+type TagFacet struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// BuildTagFacets groups the tags by category, counting the sessions which carry each
+// one, with the entries for a session exploded into several rooms counting once.  Within
+// a category the most used tags come first, and then they are by label.
+func BuildTagFacets(sessions []WatsonSession) map[string][]TagFacet {
+	// The same tag may have a different label or colour on different sessions, but it is
+	// still the one filter, labelled as it first appears
+	type facetKey struct{ category, value string }
+	sessionIDs := make(map[facetKey]map[int]bool)
+	labels := make(map[facetKey]string)
+	for _, ws := range sessions {
+		for _, tag := range ws.Tags {
+			key := facetKey{tag.Category, tag.Value}
+			if sessionIDs[key] == nil {
+				sessionIDs[key] = make(map[int]bool)
+				labels[key] = tag.Label
+			}
+			sessionIDs[key][ws.ID] = true
+		}
+	}
+
+	facets := make(map[string][]TagFacet)
+	for key, ids := range sessionIDs {
+		facets[key.category] = append(facets[key.category], TagFacet{
+			Label: labels[key],
+			Value: key.value,
+			Count: len(ids),
+		})
+	}
+	for _, categoryFacets := range facets {
+		sort.Slice(categoryFacets, func(i, j int) bool {
+			if categoryFacets[i].Count != categoryFacets[j].Count {
+				return categoryFacets[i].Count > categoryFacets[j].Count
+			}
+			if categoryFacets[i].Label != categoryFacets[j].Label {
+				return categoryFacets[i].Label < categoryFacets[j].Label
+			}
+			return categoryFacets[i].Value < categoryFacets[j].Value
+		})
+	}
+	return facets
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrackSummaries(t *testing.T) {
+	for _, mode := range []string{MULTI_ROOM_KEEP, MULTI_ROOM_EXPLODE} {
+		c := testConf(t, "")
+		c.MultiRoom = mode
+		gb, sessions := testSessions(t, c)
+		summaries := TrackSummaries(gb, sessions, SORT_BY_COUNT)
+		if len(summaries) != 2 || summaries[0].Name != "Main" || summaries[0].Count != 3 || summaries[1].Name != "Virtual" || summaries[1].Count != 1 {
+			t.Errorf("with MULTI_ROOM=%s the tracks are %+v, want Main with 3 sessions and Virtual with 1", mode, summaries)
+		}
+	}
+}
+
+func TestBuildTagFacets(t *testing.T) {
+	for _, mode := range []string{MULTI_ROOM_KEEP, MULTI_ROOM_EXPLODE} {
+		c := testConf(t, "")
+		c.MultiRoom = mode
+		_, sessions := testSessions(t, c)
+		counts := make(map[string]int)
+		for category, facets := range BuildTagFacets(sessions) {
+			for _, facet := range facets {
+				counts[category+"/"+facet.Value] = facet.Count
+			}
+		}
+		want := map[string]int{
+			"Track/track_main":              3,
+			"Track/track_virtual":           1,
+			"Environment/session_in_person": 3,
+			"Environment/session_virtual":   1,
+			"Schedule/session_all_day":      1,
+		}
+		for key, count := range want {
+			if counts[key] != count {
+				t.Errorf("with MULTI_ROOM=%s there are %d sessions with the tag %s, want %d", mode, counts[key], key, count)
+			}
+		}
+	}
+}
+
+func TestBuildTagFacetsByValue(t *testing.T) {
+	sessions := []WatsonSession{
+		{ID: 1, Tags: []Tag{{Label: "Main", Value: "track_main", Category: "Track", Color: "#ff0000"}}},
+		{ID: 2, Tags: []Tag{{Label: "Main Stage", Value: "track_main", Category: "Track"}}},
+		{ID: 3, Tags: []Tag{{Label: "Main", Value: "track_main", Category: "Venue"}}},
+	}
+	facets := BuildTagFacets(sessions)
+	want := map[string][]TagFacet{
+		"Track": {{Label: "Main", Value: "track_main", Count: 2}},
+		"Venue": {{Label: "Main", Value: "track_main", Count: 1}},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Errorf("BuildTagFacets = %+v, want %+v", facets, want)
+	}
+}
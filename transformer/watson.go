@@ -1,33 +1,102 @@
 package main
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 type WatsonSession struct {
-	ID              int      `json:"id"`
-	Locations       []string `json:"loc"`
-	Name            string   `json:"title"`
-	Description     string   `json:"desc"`
-	StartTime       string   `json:"dateTime"`
-	DurationMinutes int      `json:"mins"`
-	Format          string   `json:"format"`
-	Tags            []Tag    `json:"tags"`
-	Links           Links    `json:"links"`
-	People          []Person `json:"people,omitempty"`
-	in_person       bool     `json:"-"`
-	virtual         bool     `json:"-"`
+	ID              int       `json:"id"`
+	Key             string    `json:"key,omitempty"`
+	EntryID         string    `json:"entry_id,omitempty"`
+	Locations       []string  `json:"loc"`
+	Name            string    `json:"title"`
+	Description     string    `json:"desc"`
+	StartTime       string    `json:"dateTime"`
+	DurationMinutes int       `json:"mins"`
+	EndTime         string    `json:"endTime,omitempty"`
+	Duration        string    `json:"duration,omitempty"`
+	Format          string    `json:"format"`
+	Tags            []Tag     `json:"tags"`
+	Links           Links     `json:"links"`
+	People          []Person  `json:"people,omitempty"`
+	Languages       []string  `json:"languages,omitempty"`
+	AgeRating       string    `json:"age_rating,omitempty"`
+	ContentWarnings []string  `json:"content_warnings,omitempty"`
+	RelatedLinks    []Link    `json:"related,omitempty"`
+	StartsInMinutes *int      `json:"starts_in_mins,omitempty"`
+	GeneratedAt     string    `json:"generated_at,omitempty"`
+	Hash            string    `json:"hash,omitempty"`
+	in_person       bool      `json:"-"`
+	virtual         bool      `json:"-"`
+	locationIDs     []int     `json:"-"`
+	trackIDs        []int     `json:"-"`
+	start           time.Time `json:"-"`
+	finish          time.Time `json:"-"`
+	rank            float64   `json:"-"`
+	emitEmptyPeople bool      `json:"-"`
+	tagValues       bool      `json:"-"`
+	noReplayTitle   string    `json:"-"`
+	descriptionHTML string    `json:"-"` // As Guidebook gave it, whatever DESCRIPTION_FORMAT is
+}
+
+// MarshalJSON emits "people" as an empty array, rather than leaving it out, for sessions with
+// no people when EMIT_EMPTY_PEOPLE is set.  A session with "people": [] intentionally has
+// nobody, whereas one without "people" just has nobody we know of.  With TAG_SHAPE=values
+// the "tags" are just their values, for clients which only filter on them.
+func (ws WatsonSession) MarshalJSON() ([]byte, error) {
+	type plain WatsonSession
+	emptyPeople := ws.emitEmptyPeople && len(ws.People) == 0
+	if !emptyPeople && !ws.tagValues {
+		return json.Marshal(plain(ws))
+	}
+
+	var people any // Left out when nil, but not when an empty slice
+	if len(ws.People) > 0 {
+		people = ws.People
+	} else if emptyPeople {
+		people = make([]Person, 0)
+	}
+	var tags any = ws.Tags
+	if ws.tagValues {
+		tags = TagValues(ws.Tags)
+	}
+	return json.Marshal(struct {
+		plain
+		Tags   any `json:"tags"`
+		People any `json:"people,omitempty"`
+	}{plain(ws), tags, people})
 }
 
 type Tag struct {
 	Label    string `json:"label"`
 	Value    string `json:"value"`
 	Category string `json:"category"`
+	Color    string `json:"color,omitempty"` // Only for tracks, as set in Guidebook
+}
+
+const TAG_SHAPE_OBJECTS = "objects"
+const TAG_SHAPE_VALUES = "values"
+
+// TagValues is just the values of the tags, e.g. "track_foo"
+func TagValues(tags []Tag) []string {
+	values := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		values = append(values, tag.Value)
+	}
+	return values
 }
 
 // Links related to the session
@@ -38,6 +107,34 @@ type Links struct {
 	Chat    string `json:"chat,omitempty"`
 }
 
+const LINK_SESSION = "session"
+const LINK_STAGE = "stage"
+const LINK_REPLAY = "replay"
+const LINK_CHAT = "chat"
+
+// LINK_APP is allowed in EMIT_LINKS, but there is no app link to emit yet, so it is ignored
+const LINK_APP = "app"
+
+var allLinkTypes = []string{LINK_SESSION, LINK_STAGE, LINK_REPLAY, LINK_CHAT}
+
+// Only returns the links with just the allowed types of link populated
+func (l Links) Only(allowed []string) Links {
+	var result Links
+	if slices.Contains(allowed, LINK_SESSION) {
+		result.Session = l.Session
+	}
+	if slices.Contains(allowed, LINK_STAGE) {
+		result.Stage = l.Stage
+	}
+	if slices.Contains(allowed, LINK_REPLAY) {
+		result.Replay = l.Replay
+	}
+	if slices.Contains(allowed, LINK_CHAT) {
+		result.Chat = l.Chat
+	}
+	return result
+}
+
 type Link struct {
 	Label    string `json:"label"`
 	URL      string `json:"URL"`
@@ -45,14 +142,48 @@ type Link struct {
 }
 
 type Person struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-	Role string `json:"role,omitempty"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Role         string `json:"role,omitempty"`
+	GuestOfHonor bool   `json:"guest_of_honor,omitempty"`
+	Bio          string `json:"bio,omitempty"`
+	Photo        string `json:"photo,omitempty"`
+}
+
+// personBefore orders the people in a session: Guests of Honor first, then moderators, then
+// by role, and within a role in the program's billing order as curated in Guidebook, with
+// the name and then the ID for people of the same rank.
+func (gb GuideBook) personBefore(a, b Person) bool {
+	if a.GuestOfHonor != b.GuestOfHonor {
+		return a.GuestOfHonor
+	}
+	aModerates, bModerates := strings.EqualFold(a.Role, "Moderator"), strings.EqualFold(b.Role, "Moderator")
+	if aModerates != bModerates {
+		return aModerates
+	}
+	if a.Role != b.Role {
+		return a.Role < b.Role
+	}
+	if aRank, bRank := gb.ListItems[a.ID].Rank, gb.ListItems[b.ID].Rank; aRank != bRank {
+		return aRank < bRank
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.ID < b.ID
+}
+
+// normaliseRole turns the name of the category linking a person to a session into their role,
+// using ROLE_NAMES so that e.g. "mod" becomes "Moderator"
+func normaliseRole(category string, roleNames map[string]string) string {
+	category = strings.TrimSpace(category)
+	if role, exists := roleNames[strings.ToLower(category)]; exists {
+		return role
+	}
+	return category
 }
 
 const WATSON_TIME_FORMAT string = "2006-01-02T15:04:05.999Z07:00"
-const VIRTUAL_ROOM_1 = 5074259
-const VIRTUAL_ROOM_2 = 5074260
 
 var notAlphaNumeric = regexp.MustCompile("[^a-zA-Z0-9_]")
 
@@ -64,20 +195,44 @@ func makeTag(label, value, category string) Tag {
 	}
 }
 
+// unicodeSlug lower cases s and joins its words with underscores like makeTag, but keeps letters
+// and digits from any script, so that "日本語" and "中文" don't both become ""
+func unicodeSlug(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ':
+			return '_'
+		case r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r):
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
 // BuildSessionTags builds tags for this session
 func (ws *WatsonSession) BuildSessionTags(gs GuidebookSession, gb GuideBook) {
 	// This will at worst return an empty set - it will not return an error
 	ws.Tags = make([]Tag, 0)
 
 	for _, st := range gs.ScheduleTracks {
-		ws.Tags = append(ws.Tags, makeTag(gb.Tracks[st], "track_"+gb.Tracks[st], "Track"))
-		if strings.ToLower(gb.Tracks[st]) == "virtual" {
+		ws.trackIDs = append(ws.trackIDs, st)
+		trackTag := makeTag(gb.TrackName(st), "track_"+gb.TrackName(st), "Track")
+		trackTag.Color = gb.Tracks[st].Color
+		ws.Tags = append(ws.Tags, trackTag)
+		if strings.ToLower(gb.TrackName(st)) == "virtual" {
 			ws.virtual = true
 		}
+		for _, language := range gb.config.LanguageTracks {
+			if strings.EqualFold(gb.TrackName(st), language) {
+				ws.Languages = append(ws.Languages, language)
+				// Languages are often named in their own script, which makeTag would strip to nothing
+				ws.Tags = append(ws.Tags, Tag{Label: language, Value: "language_" + unicodeSlug(language), Category: "Language"})
+			}
+		}
 	}
 
 	for _, loc := range gs.Locations {
-		if loc == VIRTUAL_ROOM_1 || loc == VIRTUAL_ROOM_2 {
+		if gb.config.VirtualRooms[loc] {
 			ws.virtual = true
 		} else {
 			ws.in_person = true
@@ -89,67 +244,600 @@ func (ws *WatsonSession) BuildSessionTags(gs GuidebookSession, gb GuideBook) {
 	if ws.virtual {
 		ws.Tags = append(ws.Tags, makeTag("Virtual Session", "session_virtual", "Environment"))
 	}
+	if gs.AllDay {
+		ws.Tags = append(ws.Tags, makeTag("All Day", "session_all_day", "Schedule"))
+	}
+	if gb.config.FeatureTags {
+		if gs.AllowRating {
+			ws.Tags = append(ws.Tags, makeTag("Ratable", "session_ratable", "Features"))
+		}
+		if gs.AddToScheduleEnable {
+			ws.Tags = append(ws.Tags, makeTag("Schedulable", "session_schedulable", "Features"))
+		}
+	}
+	ws.Tags = dedupeTags(ws.Tags)
+}
+
+// dedupeTags drops any tag with the same value and category as one before it, such as when a
+// session is on two tracks with the same name
+func dedupeTags(tags []Tag) []Tag {
+	type tagKey struct{ value, category string }
+	seen := make(map[tagKey]bool, len(tags))
+	return slices.DeleteFunc(tags, func(tag Tag) bool {
+		key := tagKey{tag.Value, tag.Category}
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+		return false
+	})
+}
+
+// isPersonItem reports whether a list item linked to a session is a person.  Guidebook gives
+// every list item the same content type, so it is a person unless it is an age rating or content
+// warning, or we don't have it at all.  When PEOPLE_LIST_IDS is set, it has to be on one of
+// those lists (or the Guests of Honor list) too.
+func (gb GuideBook) isPersonItem(itemID int) bool {
+	item, known := gb.ListItems[itemID]
+	if !known || gb.isRatingItem(itemID) {
+		return false
+	}
+	if len(gb.config.PeopleListIDs) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(item.CustomLists, func(list int) bool {
+		return gb.config.PeopleListIDs[list] || list == gb.config.GuestsOfHonorListID
+	})
+}
+
+// isRatingItem reports whether a list item is an age rating or content warning, rather than a person
+func (gb GuideBook) isRatingItem(itemID int) bool {
+	lists := gb.ListItems[itemID].CustomLists
+	return (gb.config.AgeRatingListID != 0 && slices.Contains(lists, gb.config.AgeRatingListID)) ||
+		(gb.config.ContentWarningListID != 0 && slices.Contains(lists, gb.config.ContentWarningListID))
+}
+
+// BuildContentRatings sets the age rating and content warnings from the list items linked to
+// this session which are on the configured rating and warning lists.  A session with no
+// linked rating is unrated, and gets no age_rating.
+func (ws *WatsonSession) BuildContentRatings(gs GuidebookSession, gb GuideBook) {
+	for _, sl := range gb.SessionLinks[gs.ID].TargetIDs {
+		if sl.TargetType != GB_TARGET_TYPE_LISTITEM {
+			continue
+		}
+		item := gb.ListItems[sl.TargetID]
+		if gb.config.AgeRatingListID != 0 && slices.Contains(item.CustomLists, gb.config.AgeRatingListID) {
+			if ws.AgeRating != "" && ws.AgeRating != item.Name {
+				log.Printf("Session (%d, %s) has more than one age rating: using %q rather than %q", ws.ID, ws.Name, ws.AgeRating, item.Name)
+				continue
+			}
+			ws.AgeRating = item.Name
+		}
+		if gb.config.ContentWarningListID != 0 && slices.Contains(item.CustomLists, gb.config.ContentWarningListID) {
+			ws.ContentWarnings = append(ws.ContentWarnings, item.Name)
+		}
+	}
+
+	if ws.AgeRating != "" {
+		ws.Tags = append(ws.Tags, makeTag(ws.AgeRating, "age_rating_"+ws.AgeRating, "Age Rating"))
+	}
+	sort.Strings(ws.ContentWarnings)
+	for _, warning := range ws.ContentWarnings {
+		ws.Tags = append(ws.Tags, makeTag(warning, "content_warning_"+warning, "Content Warning"))
+	}
+}
+
+// UnmatchedNoReplayTitles returns the titles we were told have no replay which don't match any
+// streamed session, since those are probably typos.  It leaves no_replay_titles alone, so that
+// guides processed at the same time can share it.
+func UnmatchedNoReplayTitles(sessions []WatsonSession) []string {
+	matched := make(map[string]bool)
+	for _, ws := range sessions {
+		if ws.noReplayTitle != "" {
+			matched[ws.noReplayTitle] = true
+		}
+	}
+	unmatched := make([]string, 0)
+	for title := range no_replay_titles {
+		if !matched[title] {
+			unmatched = append(unmatched, title)
+		}
+	}
+	sort.Strings(unmatched)
+	return unmatched
+}
+
+const REPLAY_MATCH_EXACT = "exact"
+const REPLAY_MATCH_NORMALIZED = "normalized"
+const REPLAY_MATCH_FUZZY = "fuzzy"
+
+// normalizeTitle lower cases a title, drops its punctuation and collapses its whitespace, so that
+// "A Conversation with X " and "A conversation With X" are the same
+func normalizeTitle(title string) string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(title)) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) {
+				return -1
+			}
+			return r
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// matchNoReplayTitle finds the title we were told has no replay which is name, and how it matched.
+// With "normalized" matching it can also differ in case, whitespace and punctuation, and with "fuzzy"
+// it can then be up to distance edits away.  It gives "" when name has no such title.
+func matchNoReplayTitle(name, match string, distance int) (title string, how string) {
+	if no_replay_titles[name] {
+		return name, REPLAY_MATCH_EXACT
+	}
+	if match == REPLAY_MATCH_EXACT {
+		return "", ""
+	}
+	normalized := normalizeTitle(name)
+	if title, ok := no_replay_normalized[normalized]; ok {
+		return title, REPLAY_MATCH_NORMALIZED
+	}
+	if match == REPLAY_MATCH_NORMALIZED {
+		return "", ""
+	}
+	best := distance + 1
+	for candidate, candidateTitle := range no_replay_normalized {
+		if d := levenshtein(normalized, candidate); d < best || (d == best && candidateTitle < title) {
+			title, best = candidateTitle, d
+		}
+	}
+	if title == "" {
+		return "", ""
+	}
+	return title, REPLAY_MATCH_FUZZY
+}
+
+// levenshtein is the number of single character insertions, deletions or substitutions to turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// ClosestSessionName is the name of the session which is the fewest edits away from title,
+// ignoring case, to suggest what a title which didn't match was meant to be.
+func ClosestSessionName(title string, sessions []WatsonSession) string {
+	closest, best := "", -1
+	for _, ws := range sessions {
+		if distance := levenshtein(strings.ToLower(title), strings.ToLower(ws.Name)); best < 0 || distance < best {
+			closest, best = ws.Name, distance
+		}
+	}
+	return closest
+}
+
+// absoluteURL makes an image URL which Guidebook gave us relative to itself absolute, so
+// clients can load it.  It's relative to GB_MEDIA_BASE_URL, or by default to the site the API
+// is on, since Guidebook doesn't serve media from under the API itself.  URLs which are already
+// absolute, or which don't parse, are left alone.
+func (gb GuideBook) absoluteURL(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil || refURL.IsAbs() {
+		return ref
+	}
+	base, err := url.Parse(cmp.Or(gb.config.MediaBaseURL, gb.config.GuidebookBaseURL))
+	if err != nil {
+		return ref
+	}
+	if gb.config.MediaBaseURL == "" {
+		base = &url.URL{Scheme: base.Scheme, Host: base.Host}
+	}
+	return base.ResolveReference(refURL).String()
+}
+
+// deepLink is the link to the session, chat or replay for a session on the virtual platform
+func deepLink(baseURL, kind string, id int) string {
+	return fmt.Sprintf("%s/deep-link/%s?item_id=%d", strings.TrimRight(baseURL, "/"), kind, id)
 }
 
 // BuildSessionLinks builds the "Links" structure for this session
 func (ws *WatsonSession) BuildSessionLinks(gs GuidebookSession, gb GuideBook) {
 	if ws.virtual && stream_session_ids[ws.ID] {
-		ws.Links.Session = fmt.Sprintf("https://virtual.seattlein2025.org/deep-link/session?item_id=%d", ws.ID)
-		if !no_replay_titles[ws.Name] {
-			ws.Links.Replay = fmt.Sprintf("https://virtual.seattlein2025.org/deep-link/replay?item_id=%d", ws.ID)
+		ws.Links.Session = deepLink(gb.config.VirtualBaseURL, "session", ws.ID)
+		title, how := matchNoReplayTitle(ws.Name, gb.config.ReplayMatch, gb.config.ReplayMatchDistance)
+		if title == "" {
+			ws.Links.Replay = deepLink(gb.config.VirtualBaseURL, "replay", ws.ID)
 		} else {
-			delete(no_replay_titles, ws.Name)
+			ws.noReplayTitle = title
+			if how != REPLAY_MATCH_EXACT {
+				log.Printf("Session %d %q was taken to be the no replay title %q by %s matching", ws.ID, ws.Name, title, how)
+			}
 		}
 	}
-	ws.Links.Chat = fmt.Sprintf("https://virtual.seattlein2025.org/deep-link/chat?item_id=%d", ws.ID)
+	ws.Links.Chat = deepLink(gb.config.VirtualBaseURL, "chat", ws.ID)
+	ws.linkStreamWebViews(gs, gb)
+	ws.Links = ws.Links.Only(gb.config.EmitLinks)
+}
+
+// linkStreamWebViews uses any webviews linked from the session which point at the virtual
+// platform for its stream link, or its replay link for a replay.  These are what the program
+// team set up in Guidebook, so they win over the links we make up ourselves.
+func (ws *WatsonSession) linkStreamWebViews(gs GuidebookSession, gb GuideBook) {
+	platform, err := url.Parse(gb.config.VirtualBaseURL)
+	if err != nil {
+		return
+	}
+	targets := gb.SessionLinks[gs.ID].TargetIDs
+	ids := make([]int, 0, len(targets))
+	for id, sl := range targets {
+		if sl.TargetType == GB_TARGET_TYPE_WEBVIEW {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		wv, exists := gb.WebViews[id]
+		if !exists {
+			log.Printf("Session (%d, %s) links to webview %d, which we don't have", ws.ID, ws.Name, id)
+			continue
+		}
+		target, err := url.Parse(wv.URL)
+		if err != nil || target.Host != platform.Host {
+			continue
+		}
+		if strings.Contains(target.Path, "replay") {
+			ws.Links.Replay = wv.URL
+		} else {
+			ws.Links.Session = wv.URL
+		}
+	}
+}
+
+// BuildRelatedLinks collects the webviews linked to or from this session as related content
+func (ws *WatsonSession) BuildRelatedLinks(gs GuidebookSession, gb GuideBook, linksTo []CatLink) {
+	addWebView := func(id int, category string) {
+		wv, exists := gb.WebViews[id]
+		if !exists || wv.URL == "" {
+			return
+		}
+		ws.RelatedLinks = append(ws.RelatedLinks, Link{
+			Label:    wv.Name,
+			URL:      wv.URL,
+			Category: category,
+		})
+	}
+
+	for _, sl := range gb.SessionLinks[gs.ID].TargetIDs {
+		if sl.TargetType == GB_TARGET_TYPE_WEBVIEW {
+			addWebView(sl.TargetID, sl.Category)
+		}
+	}
+	for _, link := range linksTo {
+		if link.SourceType == GB_TARGET_TYPE_WEBVIEW {
+			addWebView(link.SourceID, link.CategoryName())
+		}
+	}
+
+	sort.Slice(ws.RelatedLinks, func(i, j int) bool {
+		if ws.RelatedLinks[i].Category != ws.RelatedLinks[j].Category {
+			return ws.RelatedLinks[i].Category < ws.RelatedLinks[j].Category
+		}
+		return ws.RelatedLinks[i].Label < ws.RelatedLinks[j].Label
+	})
+}
+
+const DURATION_MINUTES = "minutes"
+const DURATION_ISO8601 = "iso8601"
+
+// isoDuration formats a number of minutes as an ISO-8601 duration, like PT1H30M.  All-day
+// sessions are given in whole days, like P1D.
+func isoDuration(minutes int, allDay bool) string {
+	sign := ""
+	if minutes < 0 {
+		sign = "-"
+		minutes = -minutes
+	}
+	if allDay {
+		return fmt.Sprintf("%sP%dD", sign, max(1, (minutes+24*60-1)/(24*60)))
+	}
+	hours, minutes := minutes/60, minutes%60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%sPT%dM", sign, minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%sPT%dH", sign, hours)
+	}
+	return fmt.Sprintf("%sPT%dH%dM", sign, hours, minutes)
+}
+
+const LONG_SESSIONS_WARN = "warn"
+const LONG_SESSIONS_CLAMP = "clamp"
+const LONG_SESSIONS_DROP = "drop"
+
+const MULTI_ROOM_KEEP = "keep"
+const MULTI_ROOM_EXPLODE = "explode"
+
+// ExplodeMultiRoom splits each session which is in several locations into one entry per
+// location.  The entries share the session's ID, and have an EntryID of "<id>-<location id>"
+// to tell them apart.
+func ExplodeMultiRoom(sessions []WatsonSession) []WatsonSession {
+	exploded := make([]WatsonSession, 0, len(sessions))
+	for _, ws := range sessions {
+		if len(ws.locationIDs) < 2 {
+			exploded = append(exploded, ws)
+			continue
+		}
+		for i, id := range ws.locationIDs {
+			entry := ws
+			entry.EntryID = fmt.Sprintf("%d-%d", ws.ID, id)
+			entry.Locations = []string{ws.Locations[i]}
+			entry.locationIDs = []int{id}
+			exploded = append(exploded, entry)
+		}
+	}
+	return exploded
+}
+
+const OUTPUT_SHAPE_ARRAY = "array"
+const OUTPUT_SHAPE_MAP = "map"
+
+// SessionMap is the schedule keyed by session ID, for clients which look sessions up by ID.
+// A JSON object has no order, so Order lists the keys in schedule order.
+type SessionMap struct {
+	Sessions map[string]WatsonSession `json:"sessions"`
+	Order    []string                 `json:"order"`
+}
+
+// SessionsByID keys the sessions by ID, or by EntryID when multi-room sessions have been
+// exploded into several entries with the same ID.
+func SessionsByID(sessions []WatsonSession) SessionMap {
+	sm := SessionMap{
+		Sessions: make(map[string]WatsonSession, len(sessions)),
+		Order:    make([]string, 0, len(sessions)),
+	}
+	for _, ws := range sessions {
+		key := ws.EntryID
+		if key == "" {
+			key = strconv.Itoa(ws.ID)
+		}
+		sm.Sessions[key] = ws
+		sm.Order = append(sm.Order, key)
+	}
+	return sm
+}
+
+// ContentHash is a hash of what a client shows for the session, so that it can tell which
+// sessions have changed since it last looked.  People and tags are sorted first, so that
+// reordering them alone doesn't change it, and the "live" fields are left out.
+func (ws WatsonSession) ContentHash() string {
+	people := slices.Clone(ws.People)
+	sort.Slice(people, func(i, j int) bool { return people[i].ID < people[j].ID })
+	tags := slices.Clone(ws.Tags)
+	// On everything in them, so that the same value in two categories can't come out either way round
+	sort.Slice(tags, func(i, j int) bool {
+		return cmp.Or(
+			cmp.Compare(tags[i].Category, tags[j].Category),
+			cmp.Compare(tags[i].Value, tags[j].Value),
+			cmp.Compare(tags[i].Label, tags[j].Label),
+			cmp.Compare(tags[i].Color, tags[j].Color),
+		) < 0
+	})
+	locations := slices.Clone(ws.Locations)
+	sort.Strings(locations)
+
+	content, err := json.Marshal(struct {
+		Name            string
+		Description     string
+		StartTime       string
+		DurationMinutes int
+		Locations       []string
+		People          []Person
+		Links           Links
+		Tags            []Tag
+	}{ws.Name, ws.Description, ws.StartTime, ws.DurationMinutes, locations, people, ws.Links, tags})
+	if err != nil {
+		log.Fatal(err.Error()) // Plain strings and numbers, so this can't happen
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+// sessionFormat is the format of the session, e.g. "Reading", from the first of its tracks
+// which FORMAT_TRACKS maps to a format, or DEFAULT_FORMAT when none of them do.
+func (gb GuideBook) sessionFormat(gs GuidebookSession) string {
+	for _, st := range gs.ScheduleTracks {
+		if format, exists := gb.config.FormatTracks[strings.ToLower(gb.TrackName(st))]; exists {
+			return format
+		}
+	}
+	return gb.config.DefaultFormat
+}
+
+// excludedTrack returns the name of the first of the session's tracks which is excluded, if any
+func (gb GuideBook) excludedTrack(gs GuidebookSession) (string, bool) {
+	for _, st := range gs.ScheduleTracks {
+		for _, exclude := range gb.config.ExcludeTracks {
+			if strings.EqualFold(gb.TrackName(st), exclude) {
+				return gb.TrackName(st), true
+			}
+		}
+	}
+	return "", false
 }
 
 // WatsonFromGuidebook converts everything from the Guidebook structure into an array of WatsonSession.
 func WatsonFromGuidebook(gb GuideBook) ([]WatsonSession, error) {
 
 	watson := make([]WatsonSession, 0, len(gb.Sessions))
+	linksToSessions := gb.LinksToSessions()
+	now := gb.config.Now()
+	generatedAt := now.Format(WATSON_TIME_FORMAT)
 
+	excluded, untracked := 0, 0
+	tooLong := make([]int, 0)
+	badTimes := make([]int, 0)
+	tooShort := make([]int, 0)
 	for _, gs := range gb.Sessions {
+		if len(gs.ScheduleTracks) == 0 {
+			log.Printf("Session (%d, %s) is not on any track", gs.ID, gs.Name)
+			untracked++
+		}
+		if track, exclude := gb.excludedTrack(gs); exclude {
+			if gb.config.Debug {
+				log.Printf("Excluding session (%d, %s) on track %q", gs.ID, gs.Name, track)
+			}
+			excluded++
+			continue
+		}
+
 		session := WatsonSession{
-			ID:          gs.ID,
-			Name:        gs.Name,
-			Description: gs.Description,
-			StartTime:   gs.StartTime,
-			Tags:        make([]Tag, 0),
-			Links:       Links{},
-		}
-		for _, loc := range gs.Locations {
+			ID:              gs.ID,
+			Name:            gs.Name,
+			Description:     ConvertDescription(gs.Description, gb.config.DescriptionFormat),
+			descriptionHTML: gs.Description,
+			StartTime:       gs.StartTime,
+			Tags:            make([]Tag, 0),
+			Links:           Links{},
+			rank:            gs.Rank,
+			emitEmptyPeople: gb.config.EmitEmptyPeople,
+			tagValues:       gb.config.TagShape == TAG_SHAPE_VALUES,
+		}
+		if gb.config.SessionKeyTemplate != "" {
+			session.Key = strings.NewReplacer("{guide}", gs.guide, "{id}", strconv.Itoa(gs.ID)).Replace(gb.config.SessionKeyTemplate)
+		}
+		// Sorted, so that the JSON and everything derived from it come out the same every time
+		locationIDs := slices.Clone(gs.Locations)
+		sort.Slice(locationIDs, func(i, j int) bool {
+			if gb.Locations[locationIDs[i]] != gb.Locations[locationIDs[j]] {
+				return gb.Locations[locationIDs[i]] < gb.Locations[locationIDs[j]]
+			}
+			return locationIDs[i] < locationIDs[j]
+		})
+		for _, loc := range locationIDs {
 			session.Locations = append(session.Locations, gb.Locations[loc])
+			session.locationIDs = append(session.locationIDs, loc)
 		}
 		if len(session.Locations) == 0 {
-			session.Locations = append(session.Locations, "Discord") // All Hail Eris!
+			if gb.config.FallbackLocation != "" {
+				session.Locations = append(session.Locations, gb.config.FallbackLocation)
+			} else {
+				session.Locations = []string{} // So "loc" is still an array
+			}
 		}
-		start, err := time.Parse(GUIDEBOOK_TIME_FORMAT, gs.StartTime)
+		start, err := parseGuidebookTime(gs.StartTime)
 		if err != nil {
-			return watson, err
+			log.Printf("Skipping session (%d, %s) with a bad start time: %s", gs.ID, gs.Name, err.Error())
+			badTimes = append(badTimes, gs.ID)
+			continue
 		}
-		finish, err := time.Parse(GUIDEBOOK_TIME_FORMAT, gs.EndTime)
+		finish, err := parseGuidebookTime(gs.EndTime)
 		if err != nil {
-			return watson, err
+			log.Printf("Skipping session (%d, %s) with a bad end time: %s", gs.ID, gs.Name, err.Error())
+			badTimes = append(badTimes, gs.ID)
+			continue
+		}
+		if gb.config.ForceUTC {
+			start = start.UTC()
+			finish = finish.UTC()
+		} else if gb.config.EventLocation != nil {
+			// Only changes how they are shown: the durations come from the instants themselves
+			start = start.In(gb.config.EventLocation)
+			finish = finish.In(gb.config.EventLocation)
 		}
+		if gb.config.SnapToMinutes > 0 {
+			snapped := start.Round(time.Duration(gb.config.SnapToMinutes) * time.Minute)
+			if shift := snapped.Sub(start); shift != 0 {
+				log.Printf("Snapped session (%d, %s) from %s by %s", gs.ID, gs.Name, start.Format(WATSON_TIME_FORMAT), shift)
+				start = snapped
+				finish = finish.Add(shift) // Keep the same duration
+			}
+		}
+		session.start = start
+		session.finish = finish
 		session.StartTime = start.Format(WATSON_TIME_FORMAT)
 		session.DurationMinutes = int(finish.Sub(start) / time.Minute)
+		if gs.AllDay && session.DurationMinutes <= 0 {
+			// Guidebook often gives all-day items the same start and end, which means the whole day
+			session.DurationMinutes = 24 * 60
+			session.finish = start.Add(24 * time.Hour)
+		}
+		if session.DurationMinutes <= 0 {
+			// Most likely the end time was entered wrongly in Guidebook
+			log.Printf("Session (%d, %s) is %d minutes long, so ends before (or as) it starts", gs.ID, gs.Name, session.DurationMinutes)
+			tooShort = append(tooShort, gs.ID)
+			if limit := gb.config.MinDurationMinutes; limit > 0 {
+				session.DurationMinutes = limit
+				session.finish = start.Add(time.Duration(limit) * time.Minute)
+			}
+		}
+		if gb.config.DurationFormat == DURATION_ISO8601 {
+			session.Duration = isoDuration(session.DurationMinutes, gs.AllDay)
+		}
+		if gb.config.Live {
+			// Only a snapshot as at generatedAt: clients need to adjust it as time passes
+			startsIn := int(start.Sub(now) / time.Minute)
+			session.StartsInMinutes = &startsIn
+			session.GeneratedAt = generatedAt
+		}
+		if limit := gb.config.MaxDurationMinutes; limit > 0 && session.DurationMinutes > limit && !gs.AllDay {
+			log.Printf("Session (%d, %s) is implausibly long at %d minutes", gs.ID, gs.Name, session.DurationMinutes)
+			tooLong = append(tooLong, gs.ID)
+			switch gb.config.LongSessions {
+			case LONG_SESSIONS_DROP:
+				continue
+			case LONG_SESSIONS_CLAMP:
+				session.DurationMinutes = limit
+				session.finish = start.Add(time.Duration(limit) * time.Minute)
+			}
+		}
+		if gb.config.IncludeEndTime {
+			// From the start and the minutes we emit, so that clients adding them up get the same
+			// answer.  Time.Add is in absolute time, so a DST change in between doesn't skew it.
+			session.EndTime = start.Add(time.Duration(session.DurationMinutes) * time.Minute).Format(WATSON_TIME_FORMAT)
+		}
 
 		// People in the session are in CustomLinks :-/
 		personLinks, exists := gb.SessionLinks[session.ID]
 		if exists {
 			people := make([]Person, 0, len(personLinks.TargetIDs))
 			for _, pl := range personLinks.TargetIDs {
-				if pl.TargetType != GB_TARGET_TYPE_PERSON {
+				if pl.TargetType != GB_TARGET_TYPE_LISTITEM || !gb.isPersonItem(pl.TargetID) {
 					continue
 				}
+				item := gb.ListItems[pl.TargetID]
 				person := Person{
-					ID:   pl.TargetID,
-					Name: gb.ListItems[pl.TargetID].Name,
+					ID:    pl.TargetID,
+					Name:  item.Name,
+					Role:  normaliseRole(pl.Category, gb.config.RoleNames),
+					Bio:   ConvertDescription(item.Descripion, gb.config.DescriptionFormat),
+					Photo: gb.absoluteURL(cmp.Or(item.Image, item.Thumbnail)),
 				}
 				_, exists := gb.GuestsOfHonor[pl.TargetID]
-				if exists {
-					person.Role = "Guest of Honor"
+				if exists || isGuestOfHonorCategory(pl.Category) {
+					// Being a Guest of Honor doesn't stop them moderating
+					person.GuestOfHonor = true
+					if person.Role == "" {
+						person.Role = "Guest of Honor"
+					}
 				}
 				people = append(people, person)
 			}
@@ -157,22 +845,84 @@ func WatsonFromGuidebook(gb GuideBook) ([]WatsonSession, error) {
 		}
 
 		session.BuildSessionTags(gs, gb)
+		session.Format = gb.sessionFormat(gs)
 		if !(session.in_person || session.virtual) {
 			log.Printf("Somehow we have a session (%d, %s) which is neither virtual nor in person: assuming in person", session.ID, session.Name)
 			session.in_person = true
 		}
+		session.OverrideEnvironment(gb.Overrides.Sessions[gs.ID].Environment)
+		session.BuildContentRatings(gs, gb)
 		session.BuildSessionLinks(gs, gb)
+		if gb.config.ReplayTag && session.Links.Replay != "" {
+			session.Tags = append(session.Tags, makeTag("Replay Available", "has_replay", "Availability"))
+		}
+		session.BuildRelatedLinks(gs, gb, linksToSessions[gs.ID])
 
+		// The same order every time, so the schedule doesn't change just because a map did
 		sort.Slice(session.People, func(i, j int) bool {
-			return session.People[i].ID < session.People[j].ID
+			return gb.personBefore(session.People[i], session.People[j])
 		})
 
 		watson = append(watson, session)
 	}
 
-	sort.Slice(watson, func(i, j int) bool {
-		return watson[i].StartTime < watson[j].StartTime
-	})
+	if len(gb.config.ExcludeTracks) > 0 {
+		log.Printf("Excluded %d sessions on the tracks %q", excluded, gb.config.ExcludeTracks)
+	}
+	if len(badTimes) > 0 {
+		log.Printf("There were %d sessions skipped because of missing or malformed times: %v", len(badTimes), badTimes)
+		if gb.config.Strict {
+			return watson, fmt.Errorf("%d sessions have missing or malformed times", len(badTimes))
+		}
+	}
+	if len(tooShort) > 0 {
+		log.Printf("There were %d sessions with no length, or less: %v", len(tooShort), tooShort)
+		if gb.config.Strict && gb.config.MinDurationMinutes <= 0 {
+			return watson, fmt.Errorf("%d sessions end before (or as) they start", len(tooShort))
+		}
+	}
+	if len(tooLong) > 0 {
+		log.Printf("There were %d sessions longer than %d minutes (%s): %v", len(tooLong), gb.config.MaxDurationMinutes, gb.config.LongSessions, tooLong)
+		if gb.config.Strict && gb.config.LongSessions == LONG_SESSIONS_WARN {
+			return watson, fmt.Errorf("%d sessions are longer than %d minutes", len(tooLong), gb.config.MaxDurationMinutes)
+		}
+	}
+	if untracked > 0 {
+		log.Printf("There were %d sessions which are not on any track", untracked)
+		if gb.config.Strict {
+			return watson, fmt.Errorf("%d sessions are not on any track", untracked)
+		}
+	}
+
+	if gb.config.MultiRoom == MULTI_ROOM_EXPLODE {
+		watson = ExplodeMultiRoom(watson)
+	}
+	if gb.config.IncludeHashes {
+		for i := range watson {
+			watson[i].Hash = watson[i].ContentHash()
+		}
+	}
+
+	if gb.config.PreserveSourceOrder {
+		// In whatever order Guidebook gave them to us, which is only reproducible if that is
+		return watson, nil
+	}
+	sortSessions(watson)
 
 	return watson, nil
 }
+
+// sortSessions puts the sessions in the order they start, and then by ID, so that the
+// schedule is the same every time it's generated.
+func sortSessions(watson []WatsonSession) {
+	sort.Slice(watson, func(i, j int) bool {
+		// The instants, not the formatted times, which may have different offsets
+		if !watson[i].start.Equal(watson[j].start) {
+			return watson[i].start.Before(watson[j].start)
+		}
+		if watson[i].ID != watson[j].ID {
+			return watson[i].ID < watson[j].ID
+		}
+		return watson[i].EntryID < watson[j].EntryID
+	})
+}
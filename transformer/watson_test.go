@@ -0,0 +1,807 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConf is the configuration from the environment, reading the guide in testdata/guide with
+// the files in testdata/<overlay>, if there are any, in place of its own, as at a fixed time.
+func testConf(t *testing.T, overlay string) conf {
+	t.Helper()
+	c := config
+	c.LocalDataDir = filepath.Join("testdata", "guide")
+	if overlay != "" {
+		c.LocalDataDir = t.TempDir()
+		for _, dir := range []string{filepath.Join("testdata", "guide"), filepath.Join("testdata", overlay)} {
+			files, err := filepath.Glob(filepath.Join(dir, "*.raw.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(c.LocalDataDir, filepath.Base(file)), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	c.Budget = &RateBudget{}
+	c.Now = func() time.Time { return time.Date(2025, 8, 14, 17, 30, 0, 0, time.UTC) }
+	return c
+}
+
+// testSessions loads the guide and transforms it with the configuration c
+func testSessions(t *testing.T, c conf) (GuideBook, []WatsonSession) {
+	t.Helper()
+	gb, err := loadGuidebook(context.Background(), c)
+	if err != nil {
+		t.Fatalf("loadGuidebook failed: %s", err)
+	}
+	sessions, err := WatsonFromGuidebook(gb)
+	if err != nil {
+		t.Fatalf("WatsonFromGuidebook failed: %s", err)
+	}
+	return gb, sessions
+}
+
+// sessionByID finds the session with the ID, failing if there isn't one
+func sessionByID(t *testing.T, sessions []WatsonSession, id int) WatsonSession {
+	t.Helper()
+	for _, ws := range sessions {
+		if ws.ID == id {
+			return ws
+		}
+	}
+	t.Fatalf("there is no session %d", id)
+	return WatsonSession{}
+}
+
+func TestForceUTC(t *testing.T) {
+	c := testConf(t, "")
+	c.EventLocation = nil
+	for _, forceUTC := range []bool{false, true} {
+		c.ForceUTC = forceUTC
+		_, sessions := testSessions(t, c)
+		opening := sessionByID(t, sessions, 1)
+		want := "2025-08-14T10:00:00-07:00"
+		if forceUTC {
+			want = "2025-08-14T17:00:00Z"
+		}
+		if opening.StartTime != want || opening.DurationMinutes != 90 {
+			t.Errorf("with FORCE_UTC %t the session starts at %s for %d minutes, want %s for 90", forceUTC, opening.StartTime, opening.DurationMinutes, want)
+		}
+	}
+}
+
+func TestRelatedLinks(t *testing.T) {
+	_, sessions := testSessions(t, testConf(t, ""))
+	tests := []struct {
+		id   int
+		want []Link
+	}{
+		{1, nil},
+		{2, []Link{{Label: "Watch", URL: "https://virtual.seattlein2025.org/deep-link/session?item_id=2", Category: "Stream"}}}, // Linked from the session
+		{4, []Link{{Label: "Reading notes", URL: "https://example.org/notes", Category: "Notes"}}},                              // Linked to the session
+	}
+	for _, tt := range tests {
+		if got := sessionByID(t, sessions, tt.id).RelatedLinks; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("session %d has related links %+v, want %+v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// hasTag reports whether the session has a tag with the value
+func hasTag(ws WatsonSession, value string) bool {
+	return slices.Contains(TagValues(ws.Tags), value)
+}
+
+func TestReplayTag(t *testing.T) {
+	c := testConf(t, "replay")
+	c.EmitLinks = allLinkTypes
+	for _, replayTag := range []bool{false, true} {
+		c.ReplayTag = replayTag
+		_, sessions := testSessions(t, c)
+		replay := sessionByID(t, sessions, 2)
+		if replay.Links.Replay == "" {
+			t.Fatalf("session 2 has no replay link")
+		}
+		if got := hasTag(replay, "has_replay"); got != replayTag {
+			t.Errorf("with REPLAY_TAG %t the session with a replay has the tag: %t", replayTag, got)
+		}
+		if hasTag(sessionByID(t, sessions, 1), "has_replay") {
+			t.Errorf("with REPLAY_TAG %t the session with no replay has the tag", replayTag)
+		}
+	}
+}
+
+func TestSnapToMinutes(t *testing.T) {
+	tests := []struct {
+		snap     int
+		id       int
+		want     string
+		wantMins int
+	}{
+		{0, 2, "2025-08-14T17:05:00Z", 55},
+		{15, 2, "2025-08-14T17:00:00Z", 55},
+		{10, 2, "2025-08-14T17:10:00Z", 55}, // Halfway rounds up
+		{15, 1, "2025-08-14T10:00:00-07:00", 90},
+		{15, 4, "2025-08-14T18:30:00Z", 55},
+		{30, 4, "2025-08-14T18:30:00Z", 55},
+		{60, 4, "2025-08-14T19:00:00Z", 55},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.ForceUTC, c.EventLocation = false, nil
+		c.SnapToMinutes = tt.snap
+		_, sessions := testSessions(t, c)
+		ws := sessionByID(t, sessions, tt.id)
+		if ws.StartTime != tt.want || ws.DurationMinutes != tt.wantMins {
+			t.Errorf("snapped to %d minutes, session %d starts at %s for %d minutes, want %s for %d", tt.snap, tt.id, ws.StartTime, ws.DurationMinutes, tt.want, tt.wantMins)
+		}
+	}
+}
+
+func TestUnicodeSlug(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"English", "english"},
+		{"Français", "français"},
+		{"日本語", "日本語"},
+		{"Te Reo Māori", "te_reo_māori"},
+		{"C++ (beginners)", "c_beginners"},
+	}
+	for _, tt := range tests {
+		if got := unicodeSlug(tt.s); got != tt.want {
+			t.Errorf("unicodeSlug(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", ""},
+		{"{guide}:{id}", "1234:4"},
+		{"gb-{id}", "gb-4"},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.GuidebookID = "1234"
+		c.SessionKeyTemplate = tt.template
+		_, sessions := testSessions(t, c)
+		if got := sessionByID(t, sessions, 4).Key; got != tt.want {
+			t.Errorf("with SESSION_KEY_TEMPLATE %q the key is %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestLinkShapes(t *testing.T) {
+	flat, flatSessions := testSessions(t, testConf(t, ""))
+	wrapped, wrappedSessions := testSessions(t, testConf(t, "links-by-category"))
+	if !reflect.DeepEqual(flat.SessionLinks, wrapped.SessionLinks) {
+		t.Errorf("the links in categories were grouped into %+v, want %+v as from the flat links", wrapped.SessionLinks, flat.SessionLinks)
+	}
+	flatJSON, err := json.Marshal(flatSessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappedJSON, err := json.Marshal(wrappedSessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flatJSON, wrappedJSON) {
+		t.Errorf("the links in categories gave the sessions\n%s\nwant\n%s", wrappedJSON, flatJSON)
+	}
+}
+
+// sessionIDs is the IDs of the sessions, in order
+func sessionIDs(sessions []WatsonSession) []int {
+	ids := make([]int, 0, len(sessions))
+	for _, ws := range sessions {
+		ids = append(ids, ws.ID)
+	}
+	return ids
+}
+
+func TestExcludeTracks(t *testing.T) {
+	c := testConf(t, "")
+	c.ExcludeTracks = []string{"virtual"} // The track is "Virtual"
+	_, sessions := testSessions(t, c)
+	if ids := sessionIDs(sessions); slices.Contains(ids, 2) || len(ids) != 4 {
+		t.Errorf("excluding the virtual track left the sessions %v, want all but 2", ids)
+	}
+}
+
+func TestUntrackedSessions(t *testing.T) {
+	c := testConf(t, "")
+	c.Strict = false
+	_, sessions := testSessions(t, c)
+	if !slices.Contains(sessionIDs(sessions), 3) {
+		t.Errorf("the session on no track was left out when not strict")
+	}
+
+	c.Strict = true
+	gb, err := loadGuidebook(context.Background(), c)
+	if err != nil {
+		t.Fatalf("loadGuidebook failed: %s", err)
+	}
+	if _, err := WatsonFromGuidebook(gb); err == nil || !strings.Contains(err.Error(), "not on any track") {
+		t.Errorf("the session on no track gave the error %v when strict, want one about the track", err)
+	}
+}
+
+func TestMultiRoom(t *testing.T) {
+	tests := []struct {
+		mode      string
+		want      []string // The EntryID, or the ID when there isn't one, and the locations
+		wantCount int
+	}{
+		{MULTI_ROOM_KEEP, []string{"3 [Hall A Room 2]", "4 [Hall A Room 2]"}, 5},
+		{MULTI_ROOM_EXPLODE, []string{"3-10 [Hall A]", "3-11 [Room 2]", "4-10 [Hall A]", "4-11 [Room 2]"}, 7},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.MultiRoom = tt.mode
+		_, sessions := testSessions(t, c)
+		got := make([]string, 0)
+		for _, ws := range sessions {
+			if ws.ID == 3 || ws.ID == 4 {
+				got = append(got, fmt.Sprintf("%s %v", cmp.Or(ws.EntryID, strconv.Itoa(ws.ID)), ws.Locations))
+			}
+		}
+		if !slices.Equal(got, tt.want) || len(sessions) != tt.wantCount {
+			t.Errorf("with MULTI_ROOM=%s there are %d sessions with the multi-room ones %q, want %d with %q", tt.mode, len(sessions), got, tt.wantCount, tt.want)
+		}
+	}
+}
+
+func TestLongSessions(t *testing.T) {
+	tests := []struct {
+		mode     string
+		wantMins int // For the 90 minute session, or 0 if it was dropped
+	}{
+		{LONG_SESSIONS_WARN, 90},
+		{LONG_SESSIONS_CLAMP, 60},
+		{LONG_SESSIONS_DROP, 0},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.MaxDurationMinutes = 60
+		c.LongSessions = tt.mode
+		c.IncludeEndTime = true
+		_, sessions := testSessions(t, c)
+		if all := sessionByID(t, sessions, 3); all.DurationMinutes != 24*60 {
+			t.Errorf("with LONG_SESSIONS=%s the all-day session is %d minutes, want a whole day", tt.mode, all.DurationMinutes)
+		}
+		if tt.wantMins == 0 {
+			if slices.Contains(sessionIDs(sessions), 1) {
+				t.Errorf("with LONG_SESSIONS=%s the long session is still there", tt.mode)
+			}
+			continue
+		}
+		long := sessionByID(t, sessions, 1)
+		wantEnd := long.start.Add(time.Duration(tt.wantMins) * time.Minute).Format(WATSON_TIME_FORMAT)
+		if long.DurationMinutes != tt.wantMins || long.EndTime != wantEnd {
+			t.Errorf("with LONG_SESSIONS=%s the long session is %d minutes until %s, want %d until %s", tt.mode, long.DurationMinutes, long.EndTime, tt.wantMins, wantEnd)
+		}
+	}
+}
+
+func TestFieldAliases(t *testing.T) {
+	_, current := testSessions(t, testConf(t, ""))
+
+	aliases, err := loadFieldAliases(filepath.Join("testdata", "old-field-names", "aliases.json"))
+	if err != nil {
+		t.Fatalf("loadFieldAliases failed: %s", err)
+	}
+	defer func(saved map[string]map[string][]string) { fieldAliases = saved }(fieldAliases)
+	fieldAliases = aliases
+	_, old := testSessions(t, testConf(t, "old-field-names"))
+
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(currentJSON, oldJSON) {
+		t.Errorf("the old field names gave the sessions\n%s\nwant\n%s", oldJSON, currentJSON)
+	}
+}
+
+// sessionFields is the session as it is in the JSON
+func sessionFields(t *testing.T, ws WatsonSession) map[string]json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestEmitEmptyPeople(t *testing.T) {
+	for _, emitEmpty := range []bool{false, true} {
+		c := testConf(t, "")
+		c.EmitEmptyPeople = emitEmpty
+		_, sessions := testSessions(t, c)
+		for _, id := range []int{2, 5} { // Linked to nobody, and not linked to anything
+			people, present := sessionFields(t, sessionByID(t, sessions, id))["people"]
+			if present != emitEmpty || (present && string(people) != "[]") {
+				t.Errorf("with EMIT_EMPTY_PEOPLE %t session %d has people %s (%t)", emitEmpty, id, people, present)
+			}
+		}
+		if people := sessionFields(t, sessionByID(t, sessions, 1))["people"]; !strings.Contains(string(people), "Alice") {
+			t.Errorf("with EMIT_EMPTY_PEOPLE %t session 1 has the people %s", emitEmpty, people)
+		}
+	}
+}
+
+func TestTagShape(t *testing.T) {
+	c := testConf(t, "")
+	c.TagShape = TAG_SHAPE_OBJECTS
+	_, sessions := testSessions(t, c)
+	var objects []Tag
+	if err := json.Unmarshal(sessionFields(t, sessionByID(t, sessions, 2))["tags"], &objects); err != nil {
+		t.Fatalf("with TAG_SHAPE=objects the tags don't decode as objects: %s", err)
+	}
+	if !slices.ContainsFunc(objects, func(tag Tag) bool {
+		return tag == Tag{Label: "Virtual Session", Value: "session_virtual", Category: "Environment"}
+	}) {
+		t.Errorf("with TAG_SHAPE=objects the tags are %+v, want the virtual session tag among them", objects)
+	}
+
+	c.TagShape = TAG_SHAPE_VALUES
+	_, sessions = testSessions(t, c)
+	var values []string
+	if err := json.Unmarshal(sessionFields(t, sessionByID(t, sessions, 2))["tags"], &values); err != nil {
+		t.Fatalf("with TAG_SHAPE=values the tags don't decode as values: %s", err)
+	}
+	if !slices.Equal(values, TagValues(objects)) || !slices.Contains(values, "session_virtual") {
+		t.Errorf("with TAG_SHAPE=values the tags are %q, want %q", values, TagValues(objects))
+	}
+}
+
+func TestSortSessions(t *testing.T) {
+	at := func(value string) time.Time {
+		t.Helper()
+		start, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return start
+	}
+	sessions := []WatsonSession{
+		{ID: 1, start: at("2025-08-14T10:00:00+12:00")}, // 22:00 UTC the day before
+		{ID: 2, start: at("2025-08-13T23:00:00Z")},
+		{ID: 3, start: at("2025-08-13T21:30:00Z")},
+		{ID: 5, EntryID: "5-b", start: at("2025-08-13T22:00:00Z")},
+		{ID: 5, EntryID: "5-a", start: at("2025-08-14T10:00:00+12:00")},
+		{ID: 4, start: at("2025-08-13T12:00:00-10:00")}, // Also 22:00 UTC
+	}
+	sortSessions(sessions)
+
+	want := []string{"3", "1", "4", "5-a", "5-b", "2"}
+	got := make([]string, 0, len(sessions))
+	for _, ws := range sessions {
+		if ws.EntryID != "" {
+			got = append(got, ws.EntryID)
+		} else {
+			got = append(got, strconv.Itoa(ws.ID))
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("sessions sorted into %v, want %v", got, want)
+	}
+}
+
+func TestPreserveSourceOrder(t *testing.T) {
+	tests := []struct {
+		preserve bool
+		want     []int
+	}{
+		{false, []int{3, 1, 2, 4, 5}}, // The all-day Art Show starts first
+		{true, []int{1, 2, 3, 4, 5}},  // As they are in sessions.raw.json
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.PreserveSourceOrder = tt.preserve
+		c.MultiRoom = MULTI_ROOM_KEEP
+		_, sessions := testSessions(t, c)
+		if got := sessionIDs(sessions); !slices.Equal(got, tt.want) {
+			t.Errorf("with PRESERVE_SOURCE_ORDER=%t the sessions are %v, want %v", tt.preserve, got, tt.want)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "rewrites the golden files in testdata/golden with what the tests give")
+
+// checkGolden compares got with testdata/golden/<name>, or with -update replaces it
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%s\nwant what is in %s\n%s", got, path, want)
+	}
+}
+
+func TestMultiLocationGolden(t *testing.T) {
+	for _, mode := range []string{MULTI_ROOM_KEEP, MULTI_ROOM_EXPLODE} {
+		c := testConf(t, "")
+		c.MultiRoom = mode
+		c.ForceUTC, c.EventLocation = true, nil
+		c.OutputShape, c.OutputFilterCommand = OUTPUT_SHAPE_ARRAY, ""
+		_, sessions := testSessions(t, c)
+		multi := make([]WatsonSession, 0)
+		for _, ws := range sessions {
+			if ws.ID == 4 {
+				multi = append(multi, ws)
+			}
+		}
+		schedule, err := EncodeSchedule(c, multi)
+		if err != nil {
+			t.Fatalf("EncodeSchedule failed: %s", err)
+		}
+		checkGolden(t, "multi-location-"+mode+".json", schedule)
+	}
+}
+
+func TestOutputShapeMap(t *testing.T) {
+	tests := []struct {
+		mode      string
+		wantOrder []string
+	}{
+		{MULTI_ROOM_KEEP, []string{"3", "1", "2", "4", "5"}},
+		{MULTI_ROOM_EXPLODE, []string{"3-10", "3-11", "1", "2", "4-10", "4-11", "5"}},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.MultiRoom = tt.mode
+		c.OutputShape, c.OutputFilterCommand = OUTPUT_SHAPE_MAP, ""
+		_, sessions := testSessions(t, c)
+		schedule, err := EncodeSchedule(c, sessions)
+		if err != nil {
+			t.Fatalf("EncodeSchedule failed: %s", err)
+		}
+		var got struct {
+			Sessions map[string]struct {
+				ID      int    `json:"id"`
+				EntryID string `json:"entry_id"`
+			} `json:"sessions"`
+			Order []string `json:"order"`
+		}
+		if err := json.Unmarshal(schedule, &got); err != nil {
+			t.Fatalf("the map-shaped schedule doesn't decode: %s\n%s", err, schedule)
+		}
+		if !slices.Equal(got.Order, tt.wantOrder) || len(got.Sessions) != len(tt.wantOrder) {
+			t.Errorf("with MULTI_ROOM=%s the order is %q of %d sessions, want %q", tt.mode, got.Order, len(got.Sessions), tt.wantOrder)
+		}
+		for key, ws := range got.Sessions {
+			if cmp.Or(ws.EntryID, strconv.Itoa(ws.ID)) != key {
+				t.Errorf("with MULTI_ROOM=%s the key %q is for session %d (%q)", tt.mode, key, ws.ID, ws.EntryID)
+			}
+		}
+	}
+}
+
+func TestLinksOnly(t *testing.T) {
+	all := Links{Session: "https://s", Stage: "https://st", Replay: "https://r", Chat: "https://c"}
+	tests := []struct {
+		allowed []string
+		want    Links
+	}{
+		{allLinkTypes, all},
+		{[]string{LINK_SESSION}, Links{Session: "https://s"}},
+		{[]string{LINK_CHAT, LINK_REPLAY}, Links{Replay: "https://r", Chat: "https://c"}},
+		{[]string{LINK_APP}, Links{}},
+		{nil, Links{}},
+	}
+	for _, tt := range tests {
+		if got := all.Only(tt.allowed); got != tt.want {
+			t.Errorf("Only(%q) = %+v, want %+v", tt.allowed, got, tt.want)
+		}
+	}
+
+	c := testConf(t, "")
+	c.EmitLinks = []string{LINK_SESSION}
+	_, sessions := testSessions(t, c)
+	for _, ws := range sessions {
+		if ws.Links.Chat != "" || ws.Links.Replay != "" || ws.Links.Stage != "" {
+			t.Errorf("with EMIT_LINKS=session session %d has the links %+v", ws.ID, ws.Links)
+		}
+	}
+	if stream := sessionByID(t, sessions, 2).Links.Session; stream == "" {
+		t.Errorf("with EMIT_LINKS=session session 2 has no session link")
+	}
+	if links := sessionFields(t, sessionByID(t, sessions, 1))["links"]; string(links) != "{}" {
+		t.Errorf("with EMIT_LINKS=session session 1 has the links %s, want none", links)
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	tests := []struct {
+		minutes int
+		allDay  bool
+		want    string
+	}{
+		{90, false, "PT1H30M"},
+		{45, false, "PT45M"},
+		{120, false, "PT2H"},
+		{0, false, "PT0M"},
+		{-30, false, "-PT30M"},
+		{24 * 60, true, "P1D"},
+		{36 * 60, true, "P2D"},
+		{0, true, "P1D"},
+	}
+	for _, tt := range tests {
+		if got := isoDuration(tt.minutes, tt.allDay); got != tt.want {
+			t.Errorf("isoDuration(%d, %t) = %q, want %q", tt.minutes, tt.allDay, got, tt.want)
+		}
+	}
+
+	c := testConf(t, "")
+	c.DurationFormat = DURATION_ISO8601
+	_, sessions := testSessions(t, c)
+	if got := sessionByID(t, sessions, 1).Duration; got != "PT1H30M" {
+		t.Errorf("the 90 minute session has the duration %q, want PT1H30M", got)
+	}
+	if got := sessionByID(t, sessions, 3).Duration; got != "P1D" {
+		t.Errorf("the all-day session has the duration %q, want P1D", got)
+	}
+}
+
+func TestEndTimeOverDST(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("no time zone data: %s", err)
+	}
+	c := testConf(t, "dst")
+	c.ForceUTC, c.EventLocation = false, location
+	c.IncludeEndTime = true
+	_, sessions := testSessions(t, c)
+	// The clocks go back an hour at 2am, half an hour into it
+	movie := sessionByID(t, sessions, 6)
+	if movie.StartTime != "2025-11-02T01:30:00-07:00" || movie.DurationMinutes != 120 || movie.EndTime != "2025-11-02T02:30:00-08:00" {
+		t.Errorf("the session over the change is %s for %d minutes until %s, want 2025-11-02T01:30:00-07:00 for 120 until 2025-11-02T02:30:00-08:00", movie.StartTime, movie.DurationMinutes, movie.EndTime)
+	}
+}
+
+func TestPersonBefore(t *testing.T) {
+	gb := GuideBook{ListItems: map[int]ListItem{
+		1: {ID: 1, Rank: 2},
+		2: {ID: 2, Rank: 1},
+		3: {ID: 3, Rank: 1},
+	}}
+	people := []Person{
+		{ID: 10, Name: "Zed", Role: "Panelist"},
+		{ID: 1, Name: "Sam", Role: "Panelist"},
+		{ID: 11, Name: "Amy", Role: "Panelist"},
+		{ID: 12, Name: "Max", Role: "moderator"},
+		{ID: 13, Name: "Yve", Role: "Panelist", GuestOfHonor: true},
+		{ID: 14, Name: "Bea", Role: "Author"},
+		{ID: 3, Name: "Sam", Role: "Panelist"},
+		{ID: 2, Name: "Sam", Role: "Panelist"},
+	}
+	sort.Slice(people, func(i, j int) bool { return gb.personBefore(people[i], people[j]) })
+
+	// Guest of Honor, moderator, then by role, rank in Guidebook, name and finally ID
+	want := []int{13, 12, 14, 11, 10, 2, 3, 1}
+	got := make([]int, 0, len(people))
+	for _, p := range people {
+		got = append(got, p.ID)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("people sorted into %v, want %v", got, want)
+	}
+
+	// Carol is billed before Bob
+	_, sessions := testSessions(t, testConf(t, ""))
+	names := make([]string, 0)
+	for _, p := range sessionByID(t, sessions, 1).People {
+		names = append(names, p.Name)
+	}
+	if want := []string{"Alice", "Carol", "Bob"}; !slices.Equal(names, want) {
+		t.Errorf("the people in session 1 are %q, want %q", names, want)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	_, sessions := testSessions(t, testConf(t, ""))
+	ws := sessionByID(t, sessions, 1)
+	ws.Tags = append(ws.Tags, Tag{Label: "Award", Value: "award", Category: "Topic"}, Tag{Label: "Award", Value: "award", Category: "Format"})
+	if len(ws.People) < 2 {
+		t.Fatalf("session 1 has the people %+v, want several to reorder", ws.People)
+	}
+
+	reordered := ws
+	reordered.People = slices.Clone(ws.People)
+	slices.Reverse(reordered.People)
+	reordered.Tags = slices.Clone(ws.Tags)
+	slices.Reverse(reordered.Tags)
+	if ws.ContentHash() != reordered.ContentHash() {
+		t.Errorf("reordering the people and tags changed the hash")
+	}
+
+	renamed := ws
+	renamed.Name = "Closing Ceremony"
+	if ws.ContentHash() == renamed.ContentHash() {
+		t.Errorf("renaming the session didn't change the hash")
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"café", "cafe", 1}, // Characters, not bytes
+		{"same", "same", 0},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"A Conversation with X ", "a conversation with x"},
+		{"A conversation  With X", "a conversation with x"},
+		{"Workshops: The Good, the Bad, and the Ugly", "workshops the good the bad and the ugly"},
+		{"Was the Book Better Though?", "was the book better though"},
+		{" - ", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.title); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestFallbackLocation(t *testing.T) {
+	tests := []struct {
+		fallback string
+		want     string
+	}{
+		{"Discord", `["Discord"]`},
+		{"", `[]`},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.FallbackLocation = tt.fallback
+		_, sessions := testSessions(t, c)
+		// Lost Room has no locations, and Opening Ceremony has one of its own
+		if got := string(sessionFields(t, sessionByID(t, sessions, 5))["loc"]); got != tt.want {
+			t.Errorf("with FALLBACK_LOCATION=%q session 5 has the locations %s, want %s", tt.fallback, got, tt.want)
+		}
+		if got := sessionByID(t, sessions, 1).Locations; !slices.Equal(got, []string{"Hall A"}) {
+			t.Errorf("with FALLBACK_LOCATION=%q session 1 has the locations %q, want only Hall A", tt.fallback, got)
+		}
+	}
+}
+
+func TestFeatureTags(t *testing.T) {
+	tests := []struct {
+		id                   int
+		ratable, schedulable bool
+	}{
+		{1, true, true},
+		{2, false, true},
+		{3, false, false},
+	}
+	for _, featureTags := range []bool{false, true} {
+		c := testConf(t, "")
+		c.FeatureTags = featureTags
+		_, sessions := testSessions(t, c)
+		for _, tt := range tests {
+			ws := sessionByID(t, sessions, tt.id)
+			if got, want := hasTag(ws, "session_ratable"), featureTags && tt.ratable; got != want {
+				t.Errorf("with FEATURE_TAGS=%t session %d is tagged ratable %t, want %t", featureTags, tt.id, got, want)
+			}
+			if got, want := hasTag(ws, "session_schedulable"), featureTags && tt.schedulable; got != want {
+				t.Errorf("with FEATURE_TAGS=%t session %d is tagged schedulable %t, want %t", featureTags, tt.id, got, want)
+			}
+		}
+	}
+}
+
+func TestPersonPhoto(t *testing.T) {
+	tests := []struct {
+		baseURL, mediaBaseURL string
+		want                  string
+	}{
+		{"https://builder.guidebook.com/open-api/v1.1", "", "https://builder.guidebook.com/media/alice.png"},
+		{"http://127.0.0.1:8080/mock/", "", "http://127.0.0.1:8080/media/alice.png"},
+		{"https://builder.guidebook.com/open-api/v1.1", "https://cdn.example.org/guide/", "https://cdn.example.org/media/alice.png"},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "")
+		c.GuidebookBaseURL, c.MediaBaseURL = tt.baseURL, tt.mediaBaseURL
+		_, sessions := testSessions(t, c)
+		// Alice's image is /media/alice.png
+		people := sessionByID(t, sessions, 1).People
+		alice := people[slices.IndexFunc(people, func(p Person) bool { return p.ID == 500 })]
+		if alice.Photo != tt.want {
+			t.Errorf("with GB_BASE_URL=%q and GB_MEDIA_BASE_URL=%q Alice's photo is %q, want %q", tt.baseURL, tt.mediaBaseURL, alice.Photo, tt.want)
+		}
+		if bio := alice.Bio; !strings.Contains(bio, "Writes books") {
+			t.Errorf("Alice's bio is %q, want what's in her list item", bio)
+		}
+	}
+
+	gb := GuideBook{config: conf{GuidebookBaseURL: "https://builder.guidebook.com/open-api/v1.1"}}
+	for _, ref := range []string{"", "https://images.example.org/bob.jpg"} {
+		if got := gb.absoluteURL(ref); got != ref {
+			t.Errorf("absoluteURL(%q) = %q, want it unchanged", ref, got)
+		}
+	}
+}
+
+func TestPeopleLists(t *testing.T) {
+	tests := []struct {
+		peopleLists map[int]bool
+		want        []string
+	}{
+		{map[int]bool{}, []string{"Acme Books", "Bob"}}, // Every list item is someone
+		{map[int]bool{200: true}, []string{"Bob"}},
+	}
+	for _, tt := range tests {
+		c := testConf(t, "non-person-items")
+		c.PeopleListIDs = tt.peopleLists
+		_, sessions := testSessions(t, c)
+		// The Reading is linked to Bob, on the Participants list, and to Acme Books, on the Sponsors list
+		got := make([]string, 0)
+		for _, person := range sessionByID(t, sessions, 4).People {
+			got = append(got, person.Name)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("with PEOPLE_LIST_IDS %v the people in session 4 are %q, want %q", tt.peopleLists, got, tt.want)
+		}
+	}
+}
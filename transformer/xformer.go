@@ -1,35 +1,128 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	"unicode"
 )
 
 type conf struct {
-	SchedulePath    string
-	StreamPath      string
-	StreamLinksPath string
-	ChatLinksPath   string
-	ReplayLinksPath string
-	GuidebookAPIKey string
-	GuidebookID     string
-	Dump            bool
-	CSV             bool
-	Debug           bool
-	SlowDown        time.Duration
-	TimeToGo        chan (bool)
-}
-
-var (
-	config conf
-	ctx    context.Context
-)
+	SchedulePath         string
+	OutputFilterCommand  string
+	StreamPath           string
+	StreamLinksPath      string
+	ChatLinksPath        string
+	ReplayLinksPath      string
+	GuidebookAPIKey      string
+	GuidebookID          string
+	GuidebookBaseURL     string
+	MediaBaseURL         string
+	VirtualBaseURL       string
+	FallbackLocation     string
+	GuestsOfHonorListID  int
+	PeopleListIDs        map[int]bool
+	VirtualRooms         map[int]bool
+	OAuth                *OAuthTokenSource
+	Dump                 bool
+	CSV                  bool
+	CSVDelimiter         rune
+	CSVBOM               bool
+	CSVQuoteAll          bool
+	CSVDelta             bool
+	StreamsOnly          bool
+	Debug                bool
+	Strict               bool
+	ForceUTC             bool
+	EventLocation        *time.Location
+	DescriptionFormat    string
+	DurationFormat       string
+	OutputShape          string
+	TagShape             string
+	ReplayTag            bool
+	FeatureTags          bool
+	EmitLinks            []string
+	RoleNames            map[string]string
+	FormatTracks         map[string]string
+	DefaultFormat        string
+	EmitEmptyPeople      bool
+	Live                 bool
+	IncludeEndTime       bool
+	IncludeHashes        bool
+	SessionKeyTemplate   string
+	SnapToMinutes        int
+	MultiRoom            string
+	PreserveSourceOrder  bool
+	ListNestingDepth     int
+	MaxDurationMinutes   int
+	MinDurationMinutes   int
+	LongSessions         string
+	LanguageTracks       []string
+	ExcludeTracks        []string
+	AgeRatingListID      int
+	ContentWarningListID int
+	ByLocationDir        string
+	ByDayDir             string
+	ByTrackDir           string
+	FirstDay             string
+	LastDay              string
+	SplitByEnvironment   bool
+	SlotsPath            string
+	SQLitePath           string
+	TracksPath           string
+	TracksSort           string
+	FacetsPath           string
+	NowNextPath          string
+	ICSPath              string
+	SpeakersPath         string
+	DiffPath             string
+	UnmatchedReplayPath  string
+	ReplayMatch          string
+	ReplayMatchDistance  int
+	BundlePath           string
+	DumpRawDir           string
+	Since                string
+	LocalDataDir         string
+	OverridesPath        string
+	BatchManifest        string
+	Conflicts            bool
+	DuplicateTitles      bool
+	MinSessionGap        time.Duration
+	StatePath            string
+	MaxSessionCountDrop  float64
+	SlowDown             time.Duration
+	Now                  func() time.Time
+	MaxRetries           int
+	RetryDelay           time.Duration
+	RateLimitRetries     int
+	RateLimitMaxDelay    time.Duration
+	InitialRetries       int
+	Timeout              time.Duration
+	RequestTimeout       time.Duration
+	Budget               *RateBudget
+	BatchConcurrency     int
+	MaxResponseBytes     int64
+	ServeAddr            string
+	ServeInterval        time.Duration
+	TimeToGo             chan (bool)
+}
+
+var config conf
 
 func getEnvWithDefault(key string, defaultValue string) string {
 	result, present := os.LookupEnv(key)
@@ -42,18 +135,278 @@ func getEnvWithDefault(key string, defaultValue string) string {
 	return result
 }
 
+func getEnvIntWithDefault(key string, defaultValue int) int {
+	value := getEnvWithDefault(key, strconv.Itoa(defaultValue))
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer, not %q", key, value)
+	}
+	return result
+}
+
+func getEnvDurationWithDefault(key string, defaultValue time.Duration) time.Duration {
+	value := getEnvWithDefault(key, defaultValue.String())
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration like \"1s\" or \"500ms\", not %q", key, value)
+	}
+	return result
+}
+
+// getEnvList splits a comma-separated environment variable into its trimmed, non-empty parts
+func getEnvList(key string) []string {
+	return splitList(getEnvWithDefault(key, ""))
+}
+
+// getEnvIntSet reads a comma-separated list of integers, such as IDs, into a set
+func getEnvIntSet(key string, defaultValue string) map[int]bool {
+	result := make(map[int]bool)
+	for _, value := range splitList(getEnvWithDefault(key, defaultValue)) {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("%s must be a comma-separated list of numbers, not %q", key, value)
+		}
+		result[id] = true
+	}
+	return result
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping empty entries
+func splitList(list string) []string {
+	result := make([]string, 0)
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func getEnvFloatWithDefault(key string, defaultValue float64) float64 {
+	value := getEnvWithDefault(key, strconv.FormatFloat(defaultValue, 'g', -1, 64))
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number, not %q", key, value)
+	}
+	return result
+}
+
+// getEnvMap splits a comma-separated list of key=value pairs from an environment variable
+// into a map, with the keys in lower case.
+func getEnvMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range getEnvList(key) {
+		k, v, found := strings.Cut(pair, "=")
+		if !found {
+			log.Fatalf("%s must be a comma-separated list of key=value pairs, not %q", key, pair)
+		}
+		result[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return result
+}
+
 func init() {
 	config.Debug = os.Getenv("XFORMER_DEBUG") == "true"
-	config.SchedulePath = getEnvWithDefault("SCHEDULE_PATH", "/var/www/html/schedule.json")
-	config.StreamPath = getEnvWithDefault("STREAM_PATH", "/var/www/html/streaming.csv")
-	config.StreamLinksPath = getEnvWithDefault("STREAM_LINKS_PATH", "/var/www/html/stream_links.csv")
-	config.ChatLinksPath = getEnvWithDefault("CHAT_LINKS_PATH", "/var/www/html/chat_links.csv")
-	config.ReplayLinksPath = getEnvWithDefault("REPLAY_LINKS_PATH", "/var/www/html/replay_links.csv")
+	outputDir := getEnvWithDefault("OUTPUT_DIR", "/var/www/html")
+	config.SchedulePath = getEnvWithDefault("SCHEDULE_PATH", filepath.Join(outputDir, "schedule.json"))
+	config.StreamPath = getEnvWithDefault("STREAM_PATH", filepath.Join(outputDir, "streaming.csv"))
+	config.StreamLinksPath = getEnvWithDefault("STREAM_LINKS_PATH", filepath.Join(outputDir, "stream_links.csv"))
+	config.ChatLinksPath = getEnvWithDefault("CHAT_LINKS_PATH", filepath.Join(outputDir, "chat_links.csv"))
+	config.ReplayLinksPath = getEnvWithDefault("REPLAY_LINKS_PATH", filepath.Join(outputDir, "replay_links.csv"))
+	delimiter := []rune(getEnvWithDefault("CSV_DELIMITER", ","))
+	if len(delimiter) != 1 || delimiter[0] == '"' || delimiter[0] == '\r' || delimiter[0] == '\n' {
+		log.Fatalf("CSV_DELIMITER must be a single character other than a quote or newline, not %q", string(delimiter))
+	}
+	config.CSVDelimiter = delimiter[0]
+	config.CSVBOM = getEnvWithDefault("CSV_BOM", "false") == "true"
+	config.CSVQuoteAll = getEnvWithDefault("CSV_QUOTE_ALL", "false") == "true"
+	config.OutputFilterCommand = os.Getenv("OUTPUT_FILTER_COMMAND")
+	config.CSVDelta = getEnvWithDefault("CSV_DELTA", "false") == "true"
 	config.GuidebookAPIKey = getEnvWithDefault("GB_API_KEY", "not set")
+	if keyFile := getEnvWithDefault("GB_API_KEY_FILE", ""); keyFile != "" {
+		// e.g. a Docker secret, so the key isn't in our environment for all to see
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			log.Fatalf("GB_API_KEY_FILE is %q, which can't be read: %s", keyFile, err.Error())
+		}
+		config.GuidebookAPIKey = strings.TrimRightFunc(string(key), unicode.IsSpace)
+	}
 	config.GuidebookID = getEnvWithDefault("GB_ID", "not set")
+	config.GuestsOfHonorListID = getEnvIntWithDefault("GB_GOH_LIST_ID", 1153959)
+	config.PeopleListIDs = getEnvIntSet("PEOPLE_LIST_IDS", "")
+	config.VirtualRooms = getEnvIntSet("GB_VIRTUAL_ROOMS", "5074259,5074260")
+	config.FallbackLocation = getEnvWithDefault("FALLBACK_LOCATION", "Discord") // All Hail Eris!
+	config.VirtualBaseURL = getEnvWithDefault("VIRTUAL_BASE_URL", "https://virtual.seattlein2025.org")
+	if _, err := url.Parse(config.VirtualBaseURL); err != nil {
+		log.Fatalf("VIRTUAL_BASE_URL must be a URL: %s", err.Error())
+	}
+	config.GuidebookBaseURL = getEnvWithDefault("GB_BASE_URL", "https://builder.guidebook.com/open-api/v1.1")
+	config.MediaBaseURL = getEnvWithDefault("GB_MEDIA_BASE_URL", "")
+	if _, err := url.Parse(config.MediaBaseURL); err != nil {
+		log.Fatalf("GB_MEDIA_BASE_URL must be a URL: %s", err.Error())
+	}
+	if tokenURL := getEnvWithDefault("GB_OAUTH_TOKEN_URL", ""); tokenURL != "" {
+		config.OAuth = &OAuthTokenSource{
+			TokenURL:     tokenURL,
+			ClientID:     getEnvWithDefault("GB_OAUTH_CLIENT_ID", ""),
+			ClientSecret: os.Getenv("GB_OAUTH_CLIENT_SECRET"),
+		}
+	}
+	config.Now = time.Now
+	if now := getEnvWithDefault("XFORMER_NOW", ""); now != "" {
+		// Pretend it is some other time, for testing the time-dependent outputs
+		fixed, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			log.Fatalf("XFORMER_NOW must be an RFC3339 time, not %q", now)
+		}
+		config.Now = func() time.Time { return fixed }
+	}
+	aliases, err := loadFieldAliases(getEnvWithDefault("FIELD_ALIASES_PATH", ""))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fieldAliases = aliases
+	config.OverridesPath = getEnvWithDefault("OVERRIDES_PATH", "")
+	config.LocalDataDir = getEnvWithDefault("LOCAL_DATA_DIR", "")
+	config.MaxRetries = getEnvIntWithDefault("GB_MAX_RETRIES", 5)
+	config.RetryDelay = getEnvDurationWithDefault("GB_RETRY_DELAY", time.Second)
+	config.SlowDown = getEnvDurationWithDefault("GB_SLOWDOWN", 0)
+	config.RateLimitRetries = getEnvIntWithDefault("GB_RATE_LIMIT_RETRIES", 10)
+	config.RateLimitMaxDelay = getEnvDurationWithDefault("GB_RATE_LIMIT_MAX_DELAY", 2*time.Minute)
+	config.InitialRetries = getEnvIntWithDefault("GB_INITIAL_RETRIES", 5)
+	config.Timeout = getEnvDurationWithDefault("GB_TIMEOUT", 30*time.Minute)
+	config.RequestTimeout = getEnvDurationWithDefault("GB_REQUEST_TIMEOUT", time.Minute)
+	config.Budget = &RateBudget{}
+	config.BatchConcurrency = getEnvIntWithDefault("BATCH_CONCURRENCY", 4)
+	config.MaxResponseBytes = int64(getEnvIntWithDefault("GB_MAX_RESPONSE_BYTES", 64<<20))
+	config.Strict = getEnvWithDefault("STRICT", "false") == "true"
+	config.ForceUTC = getEnvWithDefault("FORCE_UTC", "false") == "true"
+	if timezone := getEnvWithDefault("EVENT_TIMEZONE", ""); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			log.Fatalf("EVENT_TIMEZONE must be an IANA time zone such as \"America/Los_Angeles\": %s", err.Error())
+		}
+		if config.ForceUTC {
+			log.Fatal("EVENT_TIMEZONE and FORCE_UTC can't both be set")
+		}
+		config.EventLocation = location
+	}
+	config.FirstDay = getEnvWithDefault("SCHEDULE_FIRST_DAY", "")
+	config.LastDay = getEnvWithDefault("SCHEDULE_LAST_DAY", "")
+	for key, day := range map[string]string{"SCHEDULE_FIRST_DAY": config.FirstDay, "SCHEDULE_LAST_DAY": config.LastDay} {
+		if _, err := time.Parse(DAY_FORMAT, day); day != "" && err != nil {
+			log.Fatalf("%s must be a date like \"2025-08-14\", not %q", key, day)
+		}
+	}
+	config.DescriptionFormat = getEnvWithDefault("DESCRIPTION_FORMAT", DESCRIPTION_HTML)
+	switch config.DescriptionFormat {
+	case DESCRIPTION_HTML, DESCRIPTION_TEXT, DESCRIPTION_MARKDOWN:
+	default:
+		log.Fatalf("DESCRIPTION_FORMAT must be one of %q, %q or %q", DESCRIPTION_HTML, DESCRIPTION_TEXT, DESCRIPTION_MARKDOWN)
+	}
+	config.DurationFormat = getEnvWithDefault("DURATION_FORMAT", DURATION_MINUTES)
+	if config.DurationFormat != DURATION_MINUTES && config.DurationFormat != DURATION_ISO8601 {
+		log.Fatalf("DURATION_FORMAT must be %q or %q", DURATION_MINUTES, DURATION_ISO8601)
+	}
+	config.OutputShape = getEnvWithDefault("OUTPUT_SHAPE", OUTPUT_SHAPE_ARRAY)
+	if config.OutputShape != OUTPUT_SHAPE_ARRAY && config.OutputShape != OUTPUT_SHAPE_MAP {
+		log.Fatalf("OUTPUT_SHAPE must be %q or %q", OUTPUT_SHAPE_ARRAY, OUTPUT_SHAPE_MAP)
+	}
+	config.TagShape = getEnvWithDefault("TAG_SHAPE", TAG_SHAPE_OBJECTS)
+	if config.TagShape != TAG_SHAPE_OBJECTS && config.TagShape != TAG_SHAPE_VALUES {
+		log.Fatalf("TAG_SHAPE must be %q or %q", TAG_SHAPE_OBJECTS, TAG_SHAPE_VALUES)
+	}
+	config.EmitEmptyPeople = getEnvWithDefault("EMIT_EMPTY_PEOPLE", "false") == "true"
+	config.Live = getEnvWithDefault("LIVE", "false") == "true"
+	config.IncludeEndTime = getEnvWithDefault("INCLUDE_END_TIME", "false") == "true"
+	config.IncludeHashes = getEnvWithDefault("INCLUDE_HASHES", "false") == "true"
+	config.EmitLinks = getEnvList("EMIT_LINKS")
+	if len(config.EmitLinks) == 0 {
+		config.EmitLinks = allLinkTypes
+	}
+	for _, linkType := range config.EmitLinks {
+		if linkType == LINK_APP {
+			log.Printf("EMIT_LINKS includes %q, but sessions have no app link to emit, so it is ignored", linkType)
+			continue
+		}
+		if !slices.Contains(allLinkTypes, linkType) {
+			log.Fatalf("EMIT_LINKS can only include %q, not %q", allLinkTypes, linkType)
+		}
+	}
+	config.RoleNames = getEnvMap("ROLE_NAMES")
+	config.FormatTracks = getEnvMap("FORMAT_TRACKS")
+	config.DefaultFormat = getEnvWithDefault("DEFAULT_FORMAT", "Panel")
+	config.ReplayTag = getEnvWithDefault("REPLAY_TAG", "false") == "true"
+	config.FeatureTags = getEnvWithDefault("FEATURE_TAGS", "false") == "true"
+	config.ListNestingDepth = getEnvIntWithDefault("LIST_NESTING_DEPTH", 0)
+	config.PreserveSourceOrder = getEnvWithDefault("PRESERVE_SOURCE_ORDER", "false") == "true"
+	config.MultiRoom = getEnvWithDefault("MULTI_ROOM", MULTI_ROOM_KEEP)
+	if config.MultiRoom != MULTI_ROOM_KEEP && config.MultiRoom != MULTI_ROOM_EXPLODE {
+		log.Fatalf("MULTI_ROOM must be %q or %q", MULTI_ROOM_KEEP, MULTI_ROOM_EXPLODE)
+	}
+	config.MaxDurationMinutes = getEnvIntWithDefault("MAX_DURATION_MINUTES", 600)
+	config.MinDurationMinutes = getEnvIntWithDefault("MIN_DURATION_MINUTES", 0)
+	config.LongSessions = getEnvWithDefault("LONG_SESSIONS", LONG_SESSIONS_WARN)
+	switch config.LongSessions {
+	case LONG_SESSIONS_WARN, LONG_SESSIONS_CLAMP, LONG_SESSIONS_DROP:
+	default:
+		log.Fatalf("LONG_SESSIONS must be one of %q, %q or %q", LONG_SESSIONS_WARN, LONG_SESSIONS_CLAMP, LONG_SESSIONS_DROP)
+	}
+	config.SessionKeyTemplate = getEnvWithDefault("SESSION_KEY_TEMPLATE", "")
+	config.SnapToMinutes = getEnvIntWithDefault("SNAP_TO_MINUTES", 0)
+	config.ExcludeTracks = getEnvList("EXCLUDE_TRACKS")
+	config.TracksSort = getEnvWithDefault("TRACKS_SORT", SORT_BY_COUNT)
+	if config.TracksSort != SORT_BY_COUNT && config.TracksSort != SORT_BY_NAME {
+		log.Fatalf("TRACKS_SORT must be %q or %q, not %q", SORT_BY_COUNT, SORT_BY_NAME, config.TracksSort)
+	}
+	config.LanguageTracks = getEnvList("LANGUAGE_TRACKS")
+	config.AgeRatingListID = getEnvIntWithDefault("AGE_RATING_LIST_ID", 0)
+	config.ContentWarningListID = getEnvIntWithDefault("CONTENT_WARNING_LIST_ID", 0)
+	config.StatePath = getEnvWithDefault("STATE_PATH", "")
+	if config.CSVDelta && config.StatePath == "" {
+		log.Fatal("CSV_DELTA needs STATE_PATH, to remember the rows from the last run")
+	}
+	config.MaxSessionCountDrop = getEnvFloatWithDefault("MAX_SESSION_COUNT_DROP", 0)
+	config.MinSessionGap = getEnvDurationWithDefault("MIN_SESSION_GAP", 15*time.Minute)
+	config.Since = getEnvWithDefault("GB_SINCE", "")
+	config.ICSPath = getEnvWithDefault("ICS_PATH", "")
+	config.SpeakersPath = getEnvWithDefault("SPEAKERS_PATH", "")
+	config.UnmatchedReplayPath = getEnvWithDefault("UNMATCHED_REPLAY_PATH", "")
+	config.ReplayMatch = getEnvWithDefault("REPLAY_MATCH", REPLAY_MATCH_NORMALIZED)
+	config.ReplayMatchDistance = getEnvIntWithDefault("REPLAY_MATCH_DISTANCE", 3)
+	config.ServeInterval = getEnvDurationWithDefault("SERVE_INTERVAL", 5*time.Minute)
+	if config.ServeInterval <= 0 {
+		log.Fatalf("SERVE_INTERVAL must be longer than zero, not %q", config.ServeInterval)
+	}
+}
 
+// parseFlags parses the command line over the configuration from the environment.  It is
+// called from main rather than init, so that tests get the flags the test binary expects.
+func parseFlags() {
 	flag.BoolVar(&config.CSV, "csv", false, "exports CSV files for stream, chat and replay links for loading into GuideBook")
 	flag.BoolVar(&config.Dump, "dump", false, "dumps the full contents we've loaded from GuideBook as JSON")
+	flag.StringVar(&config.SlotsPath, "slots", "", "writes the sessions grouped into time slots as JSON to this file")
+	flag.StringVar(&config.SQLitePath, "sqlite", "", "writes the sessions, people, locations and tags into a new SQLite database at this path")
+	flag.StringVar(&config.TracksPath, "tracks-out", "", "writes each track with the number of sessions on it as JSON to this file")
+	flag.StringVar(&config.NowNextPath, "now-next", "", "writes what is on now and next in each location as JSON to this file")
+	flag.StringVar(&config.ICSPath, "ics", config.ICSPath, "writes the sessions as an iCalendar file to this path, for subscribing to in a calendar")
+	flag.StringVar(&config.BundlePath, "bundle", "", "writes the sessions, people, tracks and locations together as one JSON document to this file")
+	flag.StringVar(&config.FacetsPath, "facets", "", "writes the tags grouped by category, with how many sessions carry each, as JSON to this file")
+	flag.StringVar(&config.DumpRawDir, "dump-raw", "", "writes the raw JSON fetched for each resource into <resource>.raw.json files in this directory")
+	flag.StringVar(&config.BatchManifest, "batch", "", "processes each of the guides listed in this JSON manifest file in turn")
+	flag.BoolVar(&config.StreamsOnly, "streams-only", false, "only outputs sessions which have a valid stream link")
+	flag.BoolVar(&config.Conflicts, "conflicts", false, "reports people who are double-booked or have a tight turnaround between rooms")
+	flag.BoolVar(&config.DuplicateTitles, "duplicate-titles", false, "reports titles which are used by more than one session")
+	flag.StringVar(&config.ByLocationDir, "by-location", "", "writes one JSON file of sessions per location, plus an index.json, into this directory")
+	flag.StringVar(&config.ByDayDir, "by-day", "", "writes the sessions starting on each day into a schedule-YYYY-MM-DD.json file in this directory")
+	flag.StringVar(&config.ByTrackDir, "by-track", "", "writes the sessions on each track into a track-<name>.json file in this directory, with track-untracked.json for those on none")
+	flag.BoolVar(&config.SplitByEnvironment, "split-by-environment", false, "also writes the in-person and virtual sessions into schedule-inperson.json and schedule-virtual.json beside SCHEDULE_PATH")
+	flag.StringVar(&config.ServeAddr, "serve", "", "runs as a daemon, fetching the schedule every SERVE_INTERVAL and serving it over HTTP on this address, e.g. :8080")
+	flag.StringVar(&config.DiffPath, "diff", "", "prints which sessions were added, removed or changed since the schedule JSON in this file, e.g. last night's schedule.json")
+	flag.StringVar(&config.ReplayMatch, "replay-match", config.ReplayMatch, "how strictly session titles must match the titles with no replay: exact, normalized or fuzzy")
+	flag.StringVar(&config.Since, "since", config.Since, "only fetches what changed in Guidebook since this RFC3339 time, merging it into the snapshot in the -dump-raw directory")
+	var stripHTML bool
+	flag.BoolVar(&stripHTML, "strip-html", false, "strips the HTML from session descriptions, the same as DESCRIPTION_FORMAT=text")
 	flag.Parse()
 
 	if !config.Dump {
@@ -62,6 +415,23 @@ func init() {
 	if config.SchedulePath == config.StreamPath {
 		log.Fatal("SCHEDULE_PATH and STREAM_PATH must be set to different values.")
 	}
+	if stripHTML {
+		if config.DescriptionFormat == DESCRIPTION_MARKDOWN {
+			log.Fatal("-strip-html can't be used with DESCRIPTION_FORMAT=markdown")
+		}
+		config.DescriptionFormat = DESCRIPTION_TEXT
+	}
+	if !slices.Contains([]string{REPLAY_MATCH_EXACT, REPLAY_MATCH_NORMALIZED, REPLAY_MATCH_FUZZY}, config.ReplayMatch) {
+		log.Fatalf("-replay-match (or REPLAY_MATCH) must be %q, %q or %q", REPLAY_MATCH_EXACT, REPLAY_MATCH_NORMALIZED, REPLAY_MATCH_FUZZY)
+	}
+	if config.Since != "" {
+		if _, err := time.Parse(time.RFC3339, config.Since); err != nil {
+			log.Fatalf("-since (or GB_SINCE) must be an RFC3339 time: %s", err.Error())
+		}
+		if config.DumpRawDir == "" {
+			log.Fatal("-since needs -dump-raw, for the snapshot to merge what changed into")
+		}
+	}
 }
 
 func DumpJSON(f io.Writer, v any) {
@@ -73,77 +443,396 @@ func DumpJSON(f io.Writer, v any) {
 
 }
 
-func main() {
-	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	// defer cancel()
+// WriteJSONFile writes v as indented JSON to the file at path, atomically replacing anything already there.
+func WriteJSONFile(path string, v any) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		DumpJSON(w, v)
+		return nil
+	})
+}
 
-	log.Println("Started fetching from Guidebook")
-	guidebook, err := loadGuidebook(config)
-	log.Println("Guidebook fetch complete")
+// FilterOutput runs command through the shell, with data on its standard input, and returns
+// whatever it writes to standard output.  Anything it writes to standard error is passed on to ours.
+func FilterOutput(command string, data []byte) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// checkOutputPath makes sure that path isn't a directory, and is in a directory where we
+// can create files, since each output is written beside it and then renamed into place.
+func checkOutputPath(key, path string) error {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		return fmt.Errorf("%s is %q, which is a directory: it must be the path of a file", key, path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s is %q, which can't be checked: %w", key, path, err)
+	}
+
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, ".xformer-check-*")
 	if err != nil {
-		log.Fatal(err.Error())
+		return fmt.Errorf("%s is %q, but we can't create files in %q: %w", key, path, dir, err)
 	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkOutputDir makes sure that dir is a directory, creating it if need be, and that we
+// can create files in it.
+func checkOutputDir(key, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("%s is %q, which can't be created as a directory: %w", key, dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".xformer-check-*")
 	if err != nil {
-		log.Fatal(err.Error())
+		return fmt.Errorf("%s is %q, but we can't create files in it: %w", key, dir, err)
 	}
-	if config.Dump {
-		DumpJSON(os.Stdout, guidebook)
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// validateCredentials makes sure we've been told which guide to fetch and how to authenticate,
+// so a misconfigured deployment fails straight away rather than with a 401 from Guidebook.
+// Nothing is needed when reading from LOCAL_DATA_DIR, and OAuth stands in for GB_API_KEY.
+func validateCredentials(c conf) error {
+	if c.LocalDataDir != "" {
+		return nil
+	}
+	unset := func(value string) bool {
+		return strings.TrimSpace(value) == "" || value == "not set"
+	}
+	if unset(c.GuidebookID) {
+		return fmt.Errorf("GB_ID must be set to the ID of the guide to fetch")
+	}
+	if c.OAuth != nil {
+		if unset(c.OAuth.ClientID) || unset(c.OAuth.ClientSecret) {
+			return fmt.Errorf("GB_OAUTH_CLIENT_ID and GB_OAUTH_CLIENT_SECRET must both be set to use GB_OAUTH_TOKEN_URL")
+		}
+	} else if unset(c.GuidebookAPIKey) {
+		return fmt.Errorf("GB_API_KEY (or GB_API_KEY_FILE) must be set to the Guidebook API key")
+	}
+	return nil
+}
+
+// EncodeSchedule gives the schedule JSON for the sessions, in the configured shape and passed
+// through the output filter command if there is one.
+func EncodeSchedule(c conf, sessions []WatsonSession) ([]byte, error) {
+	var schedule bytes.Buffer
+	if c.OutputShape == OUTPUT_SHAPE_MAP {
+		DumpJSON(&schedule, SessionsByID(sessions))
 	} else {
+		DumpJSON(&schedule, sessions)
+	}
+	if c.OutputFilterCommand == "" {
+		return schedule.Bytes(), nil
+	}
+	filtered, err := FilterOutput(c.OutputFilterCommand, schedule.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("output filter command %q failed: %w", c.OutputFilterCommand, err)
+	}
+	return filtered, nil
+}
 
-		watsonSessions, err := WatsonFromGuidebook(guidebook)
-		if err != nil {
-			log.Fatal(err.Error())
+// validateOutputPaths checks each of the files and directories we are going to write before
+// we spend any time fetching from Guidebook.
+func validateOutputPaths(c conf) error {
+	for _, p := range outputPaths(c) {
+		check := checkOutputPath
+		if p.dir {
+			check = checkOutputDir
 		}
+		if err := check(p.key, p.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		f, err := os.OpenFile(config.SchedulePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Printf("Error opening file %q for writing streaming CSV: %s", config.StreamPath, err.Error())
-		} else {
-			DumpJSON(f, watsonSessions)
-			f.Close()
+// outputPath is somewhere a run writes to, with the setting it comes from
+type outputPath struct {
+	key  string
+	path string
+	dir  bool
+}
+
+// outputPaths lists every file and directory that a run with c writes, so that they can be
+// checked before anything is fetched.
+func outputPaths(c conf) []outputPath {
+	paths := []outputPath{
+		{key: "SCHEDULE_PATH", path: c.SchedulePath},
+		{key: "STREAM_PATH", path: c.StreamPath},
+	}
+	if c.CSV {
+		paths = append(paths,
+			outputPath{key: "CHAT_LINKS_PATH", path: c.ChatLinksPath},
+			outputPath{key: "STREAM_LINKS_PATH", path: c.StreamLinksPath},
+			outputPath{key: "REPLAY_LINKS_PATH", path: c.ReplayLinksPath},
+		)
+	}
+	optional := []outputPath{
+		{key: "STATE_PATH", path: c.StatePath},
+		{key: "-slots", path: c.SlotsPath},
+		{key: "-sqlite", path: c.SQLitePath},
+		{key: "-tracks-out", path: c.TracksPath},
+		{key: "-facets", path: c.FacetsPath},
+		{key: "-now-next", path: c.NowNextPath},
+		{key: "ICS_PATH", path: c.ICSPath},
+		{key: "SPEAKERS_PATH", path: c.SpeakersPath},
+		{key: "-bundle", path: c.BundlePath},
+		{key: "UNMATCHED_REPLAY_PATH", path: c.UnmatchedReplayPath},
+		{key: "-by-location", path: c.ByLocationDir, dir: true},
+		{key: "-by-day", path: c.ByDayDir, dir: true},
+		{key: "-by-track", path: c.ByTrackDir, dir: true},
+		{key: "-dump-raw", path: c.DumpRawDir, dir: true},
+	}
+	for _, p := range optional {
+		if p.path != "" {
+			paths = append(paths, p)
 		}
+	}
+	return paths
+}
 
-		f, err = os.OpenFile(config.StreamPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Printf("Error opening file %q for writing streaming CSV: %s", config.StreamPath, err.Error())
+// run fetches everything from Guidebook for one guide and writes all of the configured outputs.
+func run(ctx context.Context, c conf) error {
+	if err := validateCredentials(c); err != nil {
+		return err
+	}
+	if !c.Dump {
+		if err := validateOutputPaths(c); err != nil {
+			return err
+		}
+	}
+	if c.DumpRawDir != "" {
+		if err := os.MkdirAll(c.DumpRawDir, 0755); err != nil {
+			return fmt.Errorf("unable to create directory %q for raw dumps: %w", c.DumpRawDir, err)
+		}
+	}
+
+	log.Println("Started fetching from Guidebook")
+	guidebook, err := loadGuidebook(ctx, c)
+	log.Println("Guidebook fetch complete")
+	if err != nil {
+		return err
+	}
+	if c.Dump {
+		guidebook.Relationships = guidebook.ResolveOtherLinks()
+		DumpJSON(os.Stdout, guidebook)
+		return nil
+	}
+
+	watsonSessions, err := WatsonFromGuidebook(guidebook)
+	if err != nil {
+		return err
+	}
+
+	previous, hadPrevious, err := loadState(c.StatePath, c.GuidebookID)
+	if err != nil {
+		return err
+	}
+	if hadPrevious {
+		log.Printf("Previous run had %d sessions, this run has %d", previous.SessionCount, len(watsonSessions))
+	}
+	if err := checkSessionCountDrop(previous, hadPrevious, len(watsonSessions), c.MaxSessionCountDrop); err != nil {
+		return err
+	}
+	state := State{
+		GuideID:      c.GuidebookID,
+		GeneratedAt:  time.Now(),
+		SessionCount: len(watsonSessions),
+	}
+
+	if err := checkVirtualStreams(c, watsonSessions); err != nil {
+		return err
+	}
+	if err := checkInPersonLocations(c, guidebook, watsonSessions); err != nil {
+		return err
+	}
+
+	if c.StreamsOnly {
+		watsonSessions = FilterStreamable(watsonSessions)
+	}
+
+	// Before we overwrite it, in case it is the schedule we're about to write
+	if c.DiffPath != "" {
+		if old, err := LoadScheduleForDiff(c.DiffPath); err != nil {
+			log.Printf("Error loading the previous schedule for -diff: %s", err.Error())
 		} else {
-			StreamingCSV(f, watsonSessions)
-			f.Close()
+			WriteScheduleDiff(os.Stdout, DiffSchedules(old, watsonSessions))
 		}
+	}
 
-		if config.CSV {
-			f, err = os.OpenFile(config.ChatLinksPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("Error opening file %q for writing streaming CSV: %s", config.ChatLinksPath, err.Error())
-			} else {
-				ChatLinksCSV(f, watsonSessions)
-				f.Close()
-			}
-			f, err = os.OpenFile(config.StreamLinksPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("Error opening file %q for writing streaming CSV: %s", config.StreamLinksPath, err.Error())
-			} else {
-				StreamLinksCSV(f, watsonSessions)
-				f.Close()
-			}
-			f, err = os.OpenFile(config.ReplayLinksPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("Error opening file %q for writing streaming CSV: %s", config.ReplayLinksPath, err.Error())
-			} else {
-				ReplayLinksCSV(f, watsonSessions)
-				f.Close()
+	scheduleBytes, err := EncodeSchedule(c, watsonSessions)
+	if err != nil {
+		return err
+	}
+	// Without these there is nothing worth remembering the state of
+	if err := writeBytesAtomic(c.SchedulePath, scheduleBytes); err != nil {
+		return fmt.Errorf("failed to write schedule to %q: %w", c.SchedulePath, err)
+	}
+	if err := writeFileAtomic(c.StreamPath, func(w io.Writer) error { return StreamingCSV(w, c, watsonSessions) }); err != nil {
+		return fmt.Errorf("failed to write CSV to %q: %w", c.StreamPath, err)
+	}
+
+	if c.Conflicts {
+		ReportPersonConflicts(FindPersonConflicts(watsonSessions, c.MinSessionGap))
+	}
+
+	if c.DuplicateTitles {
+		ReportDuplicateTitles(FindDuplicateTitles(watsonSessions))
+	}
+
+	if c.ByLocationDir != "" {
+		if err := WriteSessionsByLocation(c.ByLocationDir, watsonSessions); err != nil {
+			log.Printf("Error writing sessions by location into %q: %s", c.ByLocationDir, err.Error())
+		}
+	}
+
+	if c.ByDayDir != "" {
+		if err := WriteSessionsByDay(c.ByDayDir, watsonSessions, c.EventLocation, c.FirstDay, c.LastDay); err != nil {
+			log.Printf("Error writing sessions by day into %q: %s", c.ByDayDir, err.Error())
+		}
+	}
+
+	if c.ByTrackDir != "" {
+		if err := WriteSessionsByTrack(c.ByTrackDir, guidebook, watsonSessions); err != nil {
+			log.Printf("Error writing sessions by track into %q: %s", c.ByTrackDir, err.Error())
+		}
+	}
+
+	if c.SplitByEnvironment {
+		if err := WriteSessionsByEnvironment(c.SchedulePath, watsonSessions); err != nil {
+			log.Printf("Error writing sessions by environment: %s", err.Error())
+		}
+	}
+
+	if c.SlotsPath != "" {
+		if err := WriteJSONFile(c.SlotsPath, SessionsBySlot(watsonSessions)); err != nil {
+			log.Printf("Error writing time slots to %q: %s", c.SlotsPath, err.Error())
+		}
+	}
+
+	if c.TracksPath != "" {
+		if err := WriteJSONFile(c.TracksPath, TrackSummaries(guidebook, watsonSessions, c.TracksSort)); err != nil {
+			log.Printf("Error writing track summaries to %q: %s", c.TracksPath, err.Error())
+		}
+	}
+
+	if c.FacetsPath != "" {
+		if err := WriteJSONFile(c.FacetsPath, BuildTagFacets(watsonSessions)); err != nil {
+			log.Printf("Error writing tag facets to %q: %s", c.FacetsPath, err.Error())
+		}
+	}
+
+	if c.NowNextPath != "" {
+		if err := WriteJSONFile(c.NowNextPath, BuildNowNext(watsonSessions, c.Now(), c.EventLocation)); err != nil {
+			log.Printf("Error writing now and next to %q: %s", c.NowNextPath, err.Error())
+		}
+	}
+
+	if c.SpeakersPath != "" {
+		if err := WriteJSONFile(c.SpeakersPath, Speakers(guidebook, watsonSessions)); err != nil {
+			log.Printf("Error writing speakers to %q: %s", c.SpeakersPath, err.Error())
+		}
+	}
+
+	if c.ICSPath != "" {
+		if err := writeICSFile(c.ICSPath, watsonSessions, c.Now()); err != nil {
+			log.Printf("Error writing iCalendar to %q: %s", c.ICSPath, err.Error())
+		}
+	}
+
+	if c.BundlePath != "" {
+		if err := WriteJSONFile(c.BundlePath, BuildBundle(c, guidebook, watsonSessions)); err != nil {
+			log.Printf("Error writing bundle to %q: %s", c.BundlePath, err.Error())
+		}
+	}
+
+	if c.SQLitePath != "" {
+		if err := WriteSQLite(c.SQLitePath, watsonSessions); err != nil {
+			log.Printf("Error writing SQLite database %q: %s", c.SQLitePath, err.Error())
+		}
+	}
+
+	// Keep the links from last time for any CSV we don't write this time
+	state.Links = maps.Clone(previous.Links)
+	if c.CSV {
+		if state.Links == nil {
+			state.Links = make(map[string][]LinkRow)
+		}
+		maps.Copy(state.Links, WriteLinkCSVs(c, watsonSessions, previous, hadPrevious))
+		if unmatched := UnmatchedNoReplayTitles(watsonSessions); len(unmatched) > 0 {
+			log.Printf("There were %d titles that were not found in the sessions:\n", len(unmatched))
+			for _, title := range unmatched {
+				log.Printf("\t%s\n", title)
 			}
-			if len(no_replay_titles) > 0 {
-				log.Printf("There were %d titles that were not found in the sessions:\n", len(no_replay_titles))
-				for title := range no_replay_titles {
-					log.Printf("\t%s\n", title)
+			if c.UnmatchedReplayPath != "" {
+				if err := WriteUnmatchedReplays(c.UnmatchedReplayPath, c, unmatched, watsonSessions); err != nil {
+					log.Printf("Error writing unmatched replay titles to %q: %s", c.UnmatchedReplayPath, err.Error())
+				} else {
+					log.Printf("Wrote the unmatched replay titles to %q", c.UnmatchedReplayPath)
 				}
 			}
 		}
 	}
 
-	// // When something is written into the config.TimeToGo channel we quit.
-	// <-config.TimeToGo
+	if err := saveState(c.StatePath, state); err != nil {
+		return fmt.Errorf("failed to save state to %q: %w", c.StatePath, err)
+	}
+	return nil
+}
+
+func main() {
+	parseFlags()
+	os.Exit(realMain())
+}
+
+// realMain does the work of main, returning the exit code rather than exiting, so that
+// everything deferred gets to run first.
+func realMain() int {
+	if config.ServeAddr != "" {
+		// When something is written into the config.TimeToGo channel we quit.
+		config.TimeToGo = make(chan bool, 1)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			config.TimeToGo <- true
+		}()
+		if err := serve(config); err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+		log.Println("Xformer exiting.")
+		return 0
+	}
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		// So that a stuck Guidebook API can't hang a cron job forever
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
 
-	// log.Println("Xformer exiting.")
+	if config.BatchManifest != "" {
+		report, err := runBatch(ctx, config, config.BatchManifest)
+		if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+		if report.Failed() {
+			return 1
+		}
+		return 0
+	}
+
+	if err := run(ctx, config); err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	return 0
 }
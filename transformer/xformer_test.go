@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGetEnvWithDefault(t *testing.T) {
+	t.Setenv("XFORMER_TEST_SET", "value")
+	t.Setenv("XFORMER_TEST_EMPTY", "")
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"XFORMER_TEST_SET", "value"},
+		{"XFORMER_TEST_EMPTY", ""},
+		{"XFORMER_TEST_UNSET", "default"},
+	}
+	for _, tt := range tests {
+		if got := getEnvWithDefault(tt.key, "default"); got != tt.want {
+			t.Errorf("getEnvWithDefault(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestGetEnvIntWithDefault(t *testing.T) {
+	t.Setenv("XFORMER_TEST_INT", "42")
+	if got := getEnvIntWithDefault("XFORMER_TEST_INT", 7); got != 42 {
+		t.Errorf("getEnvIntWithDefault = %d, want 42", got)
+	}
+	if got := getEnvIntWithDefault("XFORMER_TEST_UNSET", 7); got != 7 {
+		t.Errorf("getEnvIntWithDefault unset = %d, want 7", got)
+	}
+}
+
+func TestGetEnvDurationWithDefault(t *testing.T) {
+	t.Setenv("XFORMER_TEST_DURATION", "1m30s")
+	if got := getEnvDurationWithDefault("XFORMER_TEST_DURATION", time.Second); got != 90*time.Second {
+		t.Errorf("getEnvDurationWithDefault = %s, want 1m30s", got)
+	}
+	if got := getEnvDurationWithDefault("XFORMER_TEST_UNSET", time.Second); got != time.Second {
+		t.Errorf("getEnvDurationWithDefault unset = %s, want 1s", got)
+	}
+}
+
+func TestGetEnvList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"English,Français", []string{"English", "Français"}},
+		{" a , b ,, c ", []string{"a", "b", "c"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Setenv("XFORMER_TEST_LIST", tt.value)
+		if got := getEnvList("XFORMER_TEST_LIST"); !slices.Equal(got, tt.want) {
+			t.Errorf("getEnvList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetEnvIntSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[int]bool
+	}{
+		{"1,2, 3", map[int]bool{1: true, 2: true, 3: true}},
+		{"", map[int]bool{}}, // Set to nothing, rather than left unset
+	}
+	for _, tt := range tests {
+		t.Setenv("XFORMER_TEST_INT_SET", tt.value)
+		if got := getEnvIntSet("XFORMER_TEST_INT_SET", "9"); !maps.Equal(got, tt.want) {
+			t.Errorf("getEnvIntSet(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	if got := getEnvIntSet("XFORMER_TEST_UNSET", "9"); !maps.Equal(got, map[int]bool{9: true}) {
+		t.Errorf("getEnvIntSet unset = %v, want the default", got)
+	}
+}
+
+func TestGetEnvMap(t *testing.T) {
+	t.Setenv("XFORMER_TEST_MAP", "mod=Moderator, panelist = Panelist")
+	want := map[string]string{"mod": "Moderator", "panelist": "Panelist"}
+	if got := getEnvMap("XFORMER_TEST_MAP"); !maps.Equal(got, want) {
+		t.Errorf("getEnvMap = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeScheduleFilter(t *testing.T) {
+	sessions := []WatsonSession{{ID: 1, Name: "One", Locations: []string{"Hall A"}, Tags: []Tag{}}}
+	c := conf{OutputShape: OUTPUT_SHAPE_ARRAY}
+	unfiltered, err := EncodeSchedule(c, sessions)
+	if err != nil {
+		t.Fatalf("EncodeSchedule failed: %s", err)
+	}
+
+	c.OutputFilterCommand = "cat"
+	filtered, err := EncodeSchedule(c, sessions)
+	if err != nil {
+		t.Fatalf("EncodeSchedule through cat failed: %s", err)
+	}
+	if !bytes.Equal(filtered, unfiltered) {
+		t.Errorf("through cat the schedule is\n%s\nwant it unchanged\n%s", filtered, unfiltered)
+	}
+
+	c.OutputFilterCommand = "exit 3"
+	if _, err := EncodeSchedule(c, sessions); err == nil {
+		t.Errorf("EncodeSchedule through a failing command didn't fail")
+	}
+}